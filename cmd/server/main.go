@@ -5,10 +5,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"planning-poker-go/internal/engine"
+	"planning-poker-go/internal/github"
+	"planning-poker-go/internal/jira"
 	"planning-poker-go/internal/server"
+	"planning-poker-go/internal/webhook"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -19,24 +23,75 @@ func main() {
 	slog.SetDefault(logger)
 
 	pokerEngine := engine.NewEngine()
+	pokerEngine.MaxRooms = intEnv("MAX_ROOMS", pokerEngine.MaxRooms)
+	pokerEngine.CreateLimit = intEnv("ROOM_CREATE_LIMIT", pokerEngine.CreateLimit)
+	pokerEngine.CreateWindow = durationEnv("ROOM_CREATE_WINDOW", pokerEngine.CreateWindow)
+	pokerEngine.ReconnectGrace = delayEnv("RECONNECT_GRACE", pokerEngine.ReconnectGrace)
 	hub := server.NewHub()
 	go hub.Run()
+	pokerEngine.Connected = hub.ConnectedPlayers
 
 	srv := &server.Server{
-		Engine: pokerEngine,
-		Hub:    hub,
+		Engine:         pokerEngine,
+		Hub:            hub,
+		UpdateDebounce: delayEnv("UPDATE_DEBOUNCE", 10*time.Millisecond),
+		Compression:    boolEnv("WS_COMPRESSION", false),
 	}
+	if srv.Compression {
+		slog.Info("WebSocket compression enabled, large updates use less bandwidth and more CPU")
+	}
+	hub.OnRoomEmpty = srv.ResetAbandonedRound
+
+	srv.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if srv.AdminToken == "" {
+		slog.Info("ADMIN_TOKEN not set, admin endpoints are disabled")
+	}
+
+	srv.GitHub = github.NewClient(os.Getenv("GITHUB_TOKEN"))
+
+	if jiraURL := os.Getenv("JIRA_BASE_URL"); jiraURL != "" {
+		srv.Jira = jira.NewClient(jiraURL, os.Getenv("JIRA_EMAIL"), os.Getenv("JIRA_API_TOKEN"))
+		slog.Info("Jira integration enabled", "baseUrl", jiraURL)
+	}
+
+	if webhookURL := os.Getenv("ROOM_WEBHOOK_URL"); webhookURL != "" {
+		srv.Notifier = webhook.NewNotifier(webhookURL)
+		slog.Info("Room expiry webhook enabled")
+	}
+
+	cleanupInterval := durationEnv("CLEANUP_INTERVAL", 10*time.Minute)
+	roomMaxAge := durationEnv("ROOM_MAX_AGE", 1*time.Hour)
+	// Warn at least one cleanup interval ahead so every room is warned before it closes
+	idleWarning := durationEnv("ROOM_IDLE_WARNING", cleanupInterval)
+	slog.Info("Room cleanup configured", "interval", cleanupInterval.String(), "maxAge", roomMaxAge.String(), "idleWarning", idleWarning.String())
 
 	// Cleanup goroutine
 	go func() {
 		for {
-			time.Sleep(10 * time.Minute)
-			pokerEngine.CleanupOldRooms(1 * time.Hour)
+			time.Sleep(cleanupInterval)
+			srv.CleanupOldRooms(roomMaxAge, idleWarning)
 		}
 	}()
 
+	// Full state goes out now and then in case a client missed an update
+	if refreshInterval := delayEnv("REFRESH_INTERVAL", 30*time.Second); refreshInterval > 0 {
+		go func() {
+			for {
+				time.Sleep(refreshInterval)
+				srv.RefreshRooms()
+			}
+		}()
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/create", srv.HandleCreateRoom)
+	mux.HandleFunc("GET /api/rooms/{id}", srv.HandleGetRoom)
+	mux.HandleFunc("GET /api/rooms/{id}/log", srv.HandleGetRoomLog)
+	mux.HandleFunc("DELETE /api/rooms/{id}", srv.HandleDeleteRoom)
+	mux.HandleFunc("GET /api/admin/rooms/{id}", srv.HandleAdminGetRoom)
+	mux.HandleFunc("POST /api/rooms/{id}/stories", srv.HandleAddStories)
+	mux.HandleFunc("POST /api/rooms/{id}/import/github", srv.HandleImportGitHub)
+	mux.HandleFunc("GET /api/jira/{key}", srv.HandleJiraIssue)
 	mux.HandleFunc("/ws", srv.HandleWS)
 	mux.Handle("/metrics", promhttp.Handler())
 
@@ -57,9 +112,78 @@ func main() {
 		port = "8080"
 	}
 
-	slog.Info("Server starting", "port", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
+	// Serve TLS directly when there is no proxy in front to terminate it. The
+	// UI connects its WebSocket over wss whenever the page came over https.
+	certFile, keyFile := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	if (certFile == "") != (keyFile == "") {
+		slog.Error("TLS_CERT and TLS_KEY must be set together")
+		os.Exit(1)
+	}
+
+	var err error
+	if certFile != "" {
+		slog.Info("Server starting", "port", port, "tls", true, "cert", certFile)
+		err = http.ListenAndServeTLS(":"+port, certFile, keyFile, mux)
+	} else {
+		slog.Info("Server starting", "port", port, "tls", false)
+		err = http.ListenAndServe(":"+port, mux)
+	}
+	if err != nil {
 		slog.Error("Server failed", "error", err)
 		os.Exit(1)
 	}
 }
+
+// intEnv reads a non-negative integer from the environment, falling back to
+// def when the variable is unset. Invalid values stop the server.
+func intEnv(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		slog.Error("Invalid integer in environment", "name", name, "value", raw, "error", err)
+		os.Exit(1)
+	}
+	return n
+}
+
+// boolEnv reads a boolean such as "true" or "1" from the environment, falling
+// back to def when the variable is unset. Invalid values stop the server.
+func boolEnv(name string, def bool) bool {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		slog.Error("Invalid boolean in environment", "name", name, "value", raw, "error", err)
+		os.Exit(1)
+	}
+	return b
+}
+
+// durationEnv reads a duration such as "10m" from the environment, falling
+// back to def when the variable is unset. Invalid or non-positive values stop
+// the server rather than silently running with a surprising configuration.
+func durationEnv(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		slog.Error("Invalid duration in environment", "name", name, "value", raw, "error", err)
+		os.Exit(1)
+	}
+	return d
+}
+
+// delayEnv is durationEnv for delays that 0 turns off
+func delayEnv(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d == 0 {
+		return 0
+	}
+	return durationEnv(name, def)
+}
@@ -1,13 +1,21 @@
 package engine
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"net"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"planning-poker-go/internal/metrics"
 	"planning-poker-go/internal/models"
@@ -15,94 +23,653 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	ErrRoomNotFound      = errors.New("room not found")
+	ErrPlayerNotFound    = errors.New("player not found")
+	ErrObserverVote      = errors.New("observers cannot vote")
+	ErrRevealed          = errors.New("votes have already been revealed")
+	ErrNotRevealed       = errors.New("votes have not been revealed")
+	ErrNoVotes           = errors.New("no votes have been cast")
+	ErrTooFewVotes       = errors.New("not enough votes to reveal")
+	ErrNotHost           = errors.New("only the host can do that")
+	ErrInvalidVote       = errors.New("vote is not in the active deck")
+	ErrInvalidConfidence = errors.New("unknown confidence")
+	ErrUnknownDeck       = errors.New("unknown deck")
+	ErrEmptyDeck         = errors.New("card set cannot be empty")
+	ErrInvalidSecondVote = errors.New("value is not on the room's second scale")
+	ErrLocked            = errors.New("voting is locked")
+	ErrCountdownRunning  = errors.New("a reveal countdown is already running")
+	ErrNoCountdown       = errors.New("no reveal countdown is running")
+	ErrNoStory           = errors.New("no story in that direction")
+	ErrNoActiveStory     = errors.New("there is no active story")
+	ErrInvalidEstimate   = errors.New("estimate is not in the active deck")
+	ErrInvalidPlayerType = errors.New("unknown player type")
+	ErrRoomFull          = errors.New("room is full")
+	ErrNameTooLong       = errors.New("name is too long")
+	ErrInvalidChat       = errors.New("chat message is empty or too long")
+	ErrChatDisabled      = errors.New("chat is disabled in this room")
+	ErrUnknownTrack      = errors.New("unknown track")
+	ErrTrackExists       = errors.New("a track with that name already exists")
+	ErrInvalidTrackName  = errors.New("track name is empty or too long")
+	ErrInvalidCommitment = errors.New("a sealed vote must be a hex SHA-256 commitment")
+	ErrNotOpening        = errors.New("there is no sealed vote to open")
+	ErrBadOpening        = errors.New("vote does not match its commitment")
+	ErrTooManyRooms      = errors.New("the server has reached its room limit")
+	ErrNoteTooLong       = errors.New("pinned note is too long")
+)
+
+// KickedError is returned when a kicked player tries to rejoin before the
+// room's kick cooldown is over
+type KickedError struct {
+	Until time.Time
+}
+
+func (e *KickedError) Error() string {
+	return fmt.Sprintf("you were removed from this room and can rejoin in %s", time.Until(e.Until).Round(time.Second))
+}
+
+// TooFewVotesError is returned when a reveal is refused because fewer votes
+// are in than the room's MinVotersToReveal. It matches ErrTooFewVotes.
+type TooFewVotesError struct {
+	Voted   int
+	Needed  int
+	Waiting int // Awake participants who haven't voted yet
+}
+
+func (e *TooFewVotesError) Error() string {
+	return fmt.Sprintf("revealing needs at least %d votes, %d in and %d still to vote", e.Needed, e.Voted, e.Waiting)
+}
+
+func (e *TooFewVotesError) Is(target error) bool {
+	return target == ErrTooFewVotes
+}
+
+// InvalidRoomError is returned when a room can't be created as asked, because
+// of its decks or settings rather than any fault on the server's side. It
+// unwraps to the underlying reason.
+type InvalidRoomError struct {
+	Err error
+}
+
+func (e *InvalidRoomError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidRoomError) Unwrap() error {
+	return e.Err
+}
+
+// maxRevealCountdown caps how many seconds a room may count down before revealing
+const maxRevealCountdown = 10
+
+// maxTracks caps how many parallel tracks a room can open besides its main one
+const maxTracks = 8
+
+// maxTrackNameLength bounds the names given to tracks
+const maxTrackNameLength = 32
+
+// maxStories caps the estimation queue of a single room
+const maxStories = 200
+
+// maxEstimateLength bounds free-form final estimates
+const maxEstimateLength = 32
+
+// maxCustomVoteLength bounds free-form votes in rooms that allow them
+const maxCustomVoteLength = 32
+
+// maxGroupLength bounds the player group names given at join
+const maxGroupLength = 32
+
+// maxJoinMessageLength bounds the message a room shows players as they join
+const maxJoinMessageLength = 500
+
+// maxPinnedNoteLength bounds the note a host can pin for the room
+const maxPinnedNoteLength = 200
+
+// Upper bounds for the name and chat length limits a room can set
+const (
+	maxNameLengthLimit = 100
+	maxChatLengthLimit = 5000
+)
+
+// maxKickCooldown caps how long, in seconds, a kicked player can be kept out
+const maxKickCooldown = 60 * 60
+
+// maxAutoClearAfter caps how long, in seconds, a revealed round can stay up
+// before it is cleared automatically
+const maxAutoClearAfter = 10 * 60
+
+// maxChatHistory is how many chat messages a room keeps for late joiners
+const maxChatHistory = 50
+
+// maxActivityLog is how many log entries a room keeps for late joiners
+const maxActivityLog = 100
+
 type Engine struct {
 	servers map[uuid.UUID]*models.PokerServer
 	mu      sync.RWMutex
+
+	// Most rooms held at once, 0 for no limit
+	MaxRooms int
+
+	// Room creations allowed per remote address within CreateWindow, 0 for no limit
+	CreateLimit  int
+	CreateWindow time.Duration
+	creations    map[string][]time.Time // Recent creation attempts by remote address
+	limitMu      sync.Mutex
+
+	// Connected returns the private IDs of a room's players that have an open
+	// connection, so views can report who is really online. When nil, every
+	// awake player is taken to be online.
+	Connected func(roomId uuid.UUID) map[string]bool
+
+	// NameGenerator, if set, replaces the built-in "Brave Otter" style names
+	// given to players who join without one
+	NameGenerator func() string
+
+	// NewID makes room and recovery IDs, so tests can supply a predictable
+	// sequence. It defaults to uuid.New and is called with the engine lock held.
+	NewID func() uuid.UUID
+
+	// How long a player whose connection dropped stays as they were before
+	// being marked disconnected, so a quick reconnect goes unnoticed
+	ReconnectGrace time.Duration
+	pending        map[uuid.UUID]*pendingDisconnect // By recovery ID, guarded by mu
+}
+
+// pendingDisconnect is a dropped player waiting out the reconnect grace period
+type pendingDisconnect struct {
+	roomId    uuid.UUID
+	privateId string
+	timer     *time.Timer
 }
 
 func NewEngine() *Engine {
 	return &Engine{
-		servers: make(map[uuid.UUID]*models.PokerServer),
+		servers:        make(map[uuid.UUID]*models.PokerServer),
+		CreateLimit:    10,
+		CreateWindow:   time.Minute,
+		creations:      make(map[string][]time.Time),
+		ReconnectGrace: 10 * time.Second,
+		pending:        make(map[uuid.UUID]*pendingDisconnect),
+		NewID:          uuid.New,
 	}
 }
 
-func (e *Engine) CreateRoom(desiredCardSet string) (uuid.UUID, error) {
-	cards := strings.Split(desiredCardSet, ",")
+// DeckPresets are card sets that can be asked for by name instead of listing
+// their cards
+var DeckPresets = map[string]string{
+	"fibonacci": "0,1,2,3,5,8,13,21,?,☕",
+	"tshirt":    "XS,S,M,L,XL,?,☕",
+	"time":      "1h,2h,4h,1d,2d,3d,5d",
+}
+
+// parseCardSet splits a comma-separated card set, or expands a preset name.
+// Blank and repeated cards are dropped, keeping the first of each in place, as
+// a card listed twice would split its votes in the distribution.
+func parseCardSet(cardSet string) []string {
+	if preset, ok := DeckPresets[strings.TrimSpace(cardSet)]; ok {
+		cardSet = preset
+	}
 	var cleanedCards []string
-	for _, c := range cards {
+	seen := make(map[string]bool)
+	for _, c := range strings.Split(cardSet, ",") {
 		trimmed := strings.TrimSpace(c)
-		if trimmed != "" {
+		if trimmed != "" && trimmed != models.Abstention && !seen[trimmed] {
+			seen[trimmed] = true
 			cleanedCards = append(cleanedCards, trimmed)
 		}
 	}
+	return cleanedCards
+}
+
+// validateSettings checks settings are in range, filling in defaults for
+// zero values that have one.
+func validateSettings(settings *models.RoomSettings) error {
+	switch settings.EmptyReveal {
+	case "":
+		settings.EmptyReveal = models.EmptyRevealShow
+	case models.EmptyRevealShow, models.EmptyRevealBlock:
+	default:
+		return fmt.Errorf("unknown empty reveal policy %q", settings.EmptyReveal)
+	}
+
+	if settings.SpreadThreshold < 0 {
+		return errors.New("spread threshold cannot be negative")
+	}
+
+	if settings.AutoRevealRatio == 0 {
+		settings.AutoRevealRatio = 1
+	}
+	if settings.AutoRevealRatio < 0 || settings.AutoRevealRatio > 1 {
+		return errors.New("auto reveal ratio must be between 0 and 1")
+	}
+
+	if settings.RevealCountdown < 0 || settings.RevealCountdown > maxRevealCountdown {
+		return fmt.Errorf("reveal countdown must be between 0 and %d seconds", maxRevealCountdown)
+	}
+
+	if settings.MaxPlayers < 0 {
+		return errors.New("max players cannot be negative")
+	}
+
+	if settings.KickCooldown < 0 || settings.KickCooldown > maxKickCooldown {
+		return fmt.Errorf("kick cooldown must be between 0 and %d seconds", maxKickCooldown)
+	}
+
+	if settings.AutoClearAfter < 0 || settings.AutoClearAfter > maxAutoClearAfter {
+		return fmt.Errorf("auto-clear delay must be between 0 and %d seconds", maxAutoClearAfter)
+	}
+
+	defaults := models.DefaultRoomSettings()
+	if settings.MinVotersToReveal == 0 {
+		settings.MinVotersToReveal = defaults.MinVotersToReveal
+	}
+	if settings.MinVotersToReveal < 0 {
+		return errors.New("min voters to reveal cannot be negative")
+	}
+	if settings.MaxNameLength == 0 {
+		settings.MaxNameLength = defaults.MaxNameLength
+	}
+	if settings.MaxNameLength < 0 || settings.MaxNameLength > maxNameLengthLimit {
+		return fmt.Errorf("max name length must be between 1 and %d characters", maxNameLengthLimit)
+	}
+	if settings.MaxChatLength == 0 {
+		settings.MaxChatLength = defaults.MaxChatLength
+	}
+	if settings.MaxChatLength < 0 || settings.MaxChatLength > maxChatLengthLimit {
+		return fmt.Errorf("max chat length must be between 1 and %d characters", maxChatLengthLimit)
+	}
+
+	settings.JoinMessage = cleanText(settings.JoinMessage)
+	if utf8.RuneCountInString(settings.JoinMessage) > maxJoinMessageLength {
+		return fmt.Errorf("join message is limited to %d characters", maxJoinMessageLength)
+	}
+
+	switch settings.EstimateUnit {
+	case "":
+		settings.EstimateUnit = models.UnitPoints
+	case models.UnitPoints, models.UnitTime:
+	default:
+		return fmt.Errorf("unknown estimate unit %q", settings.EstimateUnit)
+	}
+
+	for _, policy := range []*models.PermissionPolicy{&settings.RevealPolicy, &settings.ResetPolicy} {
+		switch *policy {
+		case "":
+			*policy = models.PermissionAnyone
+		case models.PermissionAnyone, models.PermissionHost:
+		default:
+			return fmt.Errorf("unknown permission policy %q", *policy)
+		}
+	}
+
+	return nil
+}
+
+// CreateRoom creates a room from a comma-separated card set and optional extra
+// named decks. The card set becomes the active "default" deck; when it is
+// empty the first named deck is used instead.
+func (e *Engine) CreateRoom(desiredCardSet string, desiredDecks map[string]string, settings models.RoomSettings) (uuid.UUID, error) {
+	decks := make(map[string][]string)
+	activeDeck := ""
+	if cleanedCards := parseCardSet(desiredCardSet); len(cleanedCards) > 0 {
+		decks[models.DefaultDeck] = cleanedCards
+		activeDeck = models.DefaultDeck
+	}
+	for name, cardSet := range desiredDecks {
+		name = strings.TrimSpace(name)
+		cleanedCards := parseCardSet(cardSet)
+		if name == "" || len(cleanedCards) == 0 {
+			slog.Warn("Attempted to create room with an empty deck", "deck", name)
+			return uuid.Nil, &InvalidRoomError{errors.New("decks must have a name and at least one card")}
+		}
+		if _, exists := decks[name]; exists {
+			return uuid.Nil, &InvalidRoomError{fmt.Errorf("duplicate deck %q", name)}
+		}
+		decks[name] = cleanedCards
+	}
 
-	if len(cleanedCards) == 0 {
+	if len(decks) == 0 {
 		slog.Warn("Attempted to create room with empty card set")
-		return uuid.Nil, errors.New("card set cannot be empty")
+		return uuid.Nil, &InvalidRoomError{ErrEmptyDeck}
+	}
+	if activeDeck == "" {
+		names := make([]string, 0, len(decks))
+		for name := range decks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		activeDeck = names[0]
+	}
+
+	if err := validateSettings(&settings); err != nil {
+		return uuid.Nil, &InvalidRoomError{err}
+	}
+	if err := checkSecondScale(settings, decks); err != nil {
+		return uuid.Nil, &InvalidRoomError{err}
 	}
 
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	id := uuid.New()
+	if e.MaxRooms > 0 && len(e.servers) >= e.MaxRooms && !e.evictIdleRoom() {
+		slog.Warn("Room limit reached", "activeRooms", len(e.servers), "maxRooms", e.MaxRooms)
+		return uuid.Nil, ErrTooManyRooms
+	}
+
+	id := e.NewID()
 	e.servers[id] = &models.PokerServer{
-		Id:      id,
-		Players: make(map[string]*models.Player),
-		CurrentSession: &models.PokerSession{
-			CardSet: cleanedCards,
-			Votes:   make(map[string]string),
-		},
-		LastAccess: time.Now(),
+		Id:             id,
+		Players:        make(map[string]*models.Player),
+		CurrentSession: models.NewSession(decks[activeDeck], decks, activeDeck),
+		Settings:       settings,
+		LastAccess:     time.Now(),
 	}
 
 	metrics.RoomsCreatedTotal.Inc()
 	metrics.ActiveRooms.Set(float64(len(e.servers)))
-	slog.Info("Room created", "roomId", id, "cardSet", desiredCardSet)
+	slog.Info("Room created", "roomId", id, "cardSet", desiredCardSet, "decks", len(decks), "activeDeck", activeDeck)
 
 	return id, nil
 }
 
-func (e *Engine) GetServer(id uuid.UUID) (*models.PokerServer, bool) {
+// Snapshot returns a deep copy of the room as it stands, for tests, exports
+// and tooling that need more than a view. Changes to the copy don't reach the
+// room, and reading it never races the room's players.
+func (e *Engine) Snapshot(id uuid.UUID) (*models.PokerServer, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	if !ok {
+		return nil, false
+	}
+	return s.Copy(), true
+}
+
+// View returns a redacted copy of the room that is safe to send to clients
+func (e *Engine) View(id uuid.UUID) (*models.ServerView, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	if !ok {
+		return nil, false
+	}
+	return e.markOnline(s, s.View()), true
+}
+
+// RecordConnection notes where a player connected from, for moderation
+func (e *Engine) RecordConnection(serverId uuid.UUID, privateId, remoteAddr, userAgent string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	server, ok := e.servers[serverId]
+	if !ok {
+		return
+	}
+	if player, ok := server.Players[privateId]; ok {
+		player.RemoteAddr = remoteAddr
+		player.UserAgent = userAgent
+	}
+}
+
+// ViewFor returns the room as the given player may see it
+func (e *Engine) ViewFor(id uuid.UUID, privateId string) (*models.ServerView, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	if !ok {
+		return nil, false
+	}
+	return e.markOnline(s, s.ViewFor(privateId)), true
+}
+
+// Views returns the room for a broadcast. When some players may not see
+// individual votes, redacted is the view for them and fullViewers holds the
+// private IDs of the players who get the full view; otherwise redacted is nil.
+// Players whose own vote neither view shows get the room as they see it in
+// personal, by private ID.
+func (e *Engine) Views(id uuid.UUID) (full, redacted *models.ServerView, fullViewers map[string]bool, personal map[string]*models.ServerView, ok bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	if !ok {
+		return nil, nil, nil, nil, false
+	}
+	full = e.markOnline(s, s.View())
+	if s.Settings.HideVotesFromObservers && !s.Settings.Anonymous {
+		redacted = e.markOnline(s, s.ViewFor(""))
+		fullViewers = make(map[string]bool, len(s.Players))
+		for privateId := range s.Players {
+			if !s.HidesVotesFrom(privateId) {
+				fullViewers[privateId] = true
+			}
+		}
+	}
+	for privateId := range s.Players {
+		if !s.SeesOnlyOwnVote(privateId) {
+			continue
+		}
+		if personal == nil {
+			personal = make(map[string]*models.ServerView)
+		}
+		personal[privateId] = e.markOnline(s, s.ViewFor(privateId))
+	}
+	return full, redacted, fullViewers, personal, true
+}
+
+// markOnline fills in which of the view's players are online. The caller must
+// hold the engine lock.
+func (e *Engine) markOnline(s *models.PokerServer, view *models.ServerView) *models.ServerView {
+	var connected map[string]bool
+	if e.Connected != nil {
+		connected = e.Connected(s.Id)
+	}
+	for privateId, p := range s.Players {
+		key := fmt.Sprintf("%d", p.PublicId)
+		player := view.Players[key]
+		_, reconnecting := e.pending[p.RecoveryId]
+		player.Online = p.Mode == models.Awake && (connected == nil || connected[privateId] || reconnecting)
+		view.Players[key] = player
+	}
+	return view
+}
+
+func (e *Engine) Config(id uuid.UUID) (*models.RoomConfig, bool) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	if !ok {
+		return nil, false
+	}
+	return s.Config(), true
+}
+
+// Touch marks the room as recently used so cleanup keeps it around
+func (e *Engine) Touch(id uuid.UUID) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.room(id)
+	return ok
+}
+
+// room looks up a room that a player is acting on and marks it recently
+// used, so any change made through the engine keeps an active room from
+// being cleaned up. The caller must hold the write lock. Housekeeping such as
+// disconnects, log entries and cleanup itself looks rooms up directly.
+func (e *Engine) room(id uuid.UUID) (*models.PokerServer, bool) {
 	s, ok := e.servers[id]
 	if ok {
 		s.LastAccess = time.Now()
+		repairSession(s)
 	}
 	return s, ok
 }
 
-func (e *Engine) JoinRoom(id uuid.UUID, recoveryId uuid.UUID, playerName string, privateId string, pType models.PlayerType) (*models.Player, error) {
+// fallbackCards are the cards a room gets when its main session's are lost:
+// those of one of its tracks, which started out on the same deck, or failing
+// that the fibonacci preset
+func fallbackCards(server *models.PokerServer) []string {
+	names := make([]string, 0, len(server.Tracks))
+	for name := range server.Tracks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cards := server.Tracks[name].CardSet; len(cards) > 0 {
+			return slices.Clone(cards)
+		}
+	}
+	return parseCardSet("fibonacci")
+}
+
+// repairSession gives a room that is missing its main session, or the decks
+// or maps of one, a working one in its place, so partial state can't panic
+// the engine or leave the room with nothing to vote on. The caller must hold
+// the write lock.
+func repairSession(server *models.PokerServer) {
+	session := server.CurrentSession
+	if session == nil {
+		slog.Warn("Room had no session, starting a new one", "roomId", server.Id)
+		cards := fallbackCards(server)
+		server.CurrentSession = models.NewSession(cards, map[string][]string{models.DefaultDeck: cards}, models.DefaultDeck)
+		return
+	}
+	if len(session.CardSet) == 0 || session.ActiveDeck == "" || len(session.Decks[session.ActiveDeck]) == 0 {
+		slog.Warn("Room session was missing its deck, restoring one", "roomId", server.Id)
+		if len(session.CardSet) == 0 {
+			session.CardSet = fallbackCards(server)
+		}
+		if session.ActiveDeck == "" {
+			session.ActiveDeck = models.DefaultDeck
+		}
+		if session.Decks == nil {
+			session.Decks = make(map[string][]string)
+		}
+		if len(session.Decks[session.ActiveDeck]) == 0 {
+			session.Decks[session.ActiveDeck] = slices.Clone(session.CardSet)
+		}
+	}
+	if session.Votes == nil || session.Confidence == nil || session.VotedAt == nil || session.SecondVotes == nil || session.Sealed == nil {
+		slog.Warn("Room session was missing its votes, starting a new round", "roomId", server.Id)
+		resetRound(session)
+	}
+}
+
+func (e *Engine) Settings(id uuid.UUID) (models.RoomSettings, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	if !ok {
+		return models.RoomSettings{}, false
+	}
+	return s.Settings, true
+}
+
+// IsHost reports whether the private ID belongs to the room's host
+func (e *Engine) IsHost(id uuid.UUID, privateId string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	s, ok := e.servers[id]
+	return ok && isHost(s, privateId)
+}
+
+// JoinRoom adds a player to the room, or resumes an existing player whose
+// recovery ID matches. The player is given a new private ID either way, and a
+// resumed player a fresh recovery ID too, so the old ones stop working and a
+// leaked ID is only useful for so long. A player who comes back as an
+// observer loses their vote, which can move the round on as reported. A
+// newcomer from the address of a player kicked in the cooldown is turned away
+// like the kicked player would be. The returned player is a copy.
+func (e *Engine) JoinRoom(id uuid.UUID, recoveryId uuid.UUID, playerName string, pType models.PlayerType, group, remoteAddr string) (*models.Player, RoundChange, error) {
+	group, err := cleanGroup(group)
+	if err != nil {
+		return nil, RoundUnchanged, err
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	server, ok := e.servers[id]
+	// The nil ID is what a client with nothing to recover sends, so it never
+	// matches anyone and the new player is given an ID of their own
+	if recoveryId == uuid.Nil {
+		recoveryId = e.NewID()
+	}
+
+	server, ok := e.room(id)
 	if !ok {
 		slog.Warn("Player tried to join non-existent room", "roomId", id)
-		return nil, errors.New("room not found")
+		return nil, RoundUnchanged, ErrRoomNotFound
+	}
+
+	if until, banned := server.Bans[recoveryId]; banned {
+		if time.Now().Before(until) {
+			return nil, RoundUnchanged, &KickedError{Until: until}
+		}
+		delete(server.Bans, recoveryId)
+	}
+	// A fresh recovery ID is free to ask for, so a newcomer's address is
+	// checked too. Players already in the room may share it, e.g. behind an
+	// office NAT, and keep resuming as before.
+	if host := addrHost(remoteAddr); host != "" && !hasRecoveryId(server, recoveryId) {
+		if until, banned := server.AddrBans[host]; banned {
+			if time.Now().Before(until) {
+				return nil, RoundUnchanged, &KickedError{Until: until}
+			}
+			delete(server.AddrBans, host)
+		}
 	}
 
+	playerName, err = cleanName(playerName, server.Settings)
+	if err != nil {
+		return nil, RoundUnchanged, err
+	}
+
+	privateId := newPrivateId()
+
 	// Check if player is recovering
 	for _, p := range server.Players {
 		if p.RecoveryId == recoveryId {
 			// Update existing player
-			delete(server.Players, p.Id) // Remove old mapping if private ID changed
+			delete(server.Players, p.Id)
 			p.Id = privateId
+			p.RecoveryId = e.NewID()
 			p.Mode = models.Awake
 			// Only update name/type if they were provided and not empty
 			if playerName != "" {
 				p.Name = playerName
 			}
+			change := RoundUnchanged
 			if pType != "" {
 				p.Type = pType
+				// An observer's vote mustn't count, however they came back
+				if pType == models.Observer {
+					change = settleRound(server, p.Track, removeVote(server, p))
+				}
+			}
+			if group != "" {
+				p.Group = group
 			}
 			server.Players[privateId] = p
 			slog.Info("Player recovered session", "roomId", id, "playerName", p.Name, "type", p.Type)
-			return p, nil
+			recovered := *p
+			return &recovered, change, nil
 		}
 	}
 
 	// New player
+	if server.Settings.MaxPlayers > 0 && len(server.Players) >= server.Settings.MaxPlayers {
+		return nil, RoundUnchanged, ErrRoomFull
+	}
+	if playerName == "" {
+		playerName = e.generateName(server)
+	}
+	if pType == "" {
+		pType = models.Participant
+		if server.Settings.JoinAsObserver {
+			pType = models.Observer
+		}
+	}
+
 	publicId := 1
 	if len(server.Players) > 0 {
 		var ids []int
@@ -112,6 +679,7 @@ func (e *Engine) JoinRoom(id uuid.UUID, recoveryId uuid.UUID, playerName string,
 		sort.Ints(ids)
 		publicId = ids[len(ids)-1] + 1
 	}
+	clearStaleVotes(server, publicId)
 
 	player := &models.Player{
 		Id:         privateId,
@@ -120,174 +688,1740 @@ func (e *Engine) JoinRoom(id uuid.UUID, recoveryId uuid.UUID, playerName string,
 		Name:       playerName,
 		Type:       pType,
 		Mode:       models.Awake,
+		Group:      group,
+		Color:      models.PlayerColor(publicId),
 	}
 
 	server.Players[privateId] = player
+	if server.HostId == 0 {
+		server.HostId = publicId
+		slog.Info("Player is now host", "roomId", id, "playerName", playerName)
+	}
 	
 	metrics.ActivePlayers.Inc()
 	metrics.PlayersPerRoom.Observe(float64(len(server.Players)))
 	slog.Info("Player joined room", "roomId", id, "playerName", playerName, "type", pType, "totalPlayers", len(server.Players))
 	
-	return player, nil
+	joined := *player
+	return &joined, RoundUnchanged, nil
+}
+
+type VoteResult struct {
+	PublicId     int  // The voter
+	AutoRevealed bool // The vote met the room's auto-reveal threshold
+	Changed      bool // The player replaced an earlier, different vote
 }
 
-func (e *Engine) Vote(serverId uuid.UUID, privateId string, vote string) error {
+// Vote casts a player's vote. second is their value on the room's second
+// scale, and may be left empty even when the room has one.
+func (e *Engine) Vote(serverId uuid.UUID, privateId string, vote string, second string, confidence models.Confidence) (VoteResult, error) {
+	return e.castVote(serverId, privateId, vote, second, confidence, false)
+}
+
+// Abstain records that a player has no estimate to give. An abstention counts
+// as a vote towards reveal but is left out of the numeric stats. It is never
+// sealed, as there is nothing to hide.
+func (e *Engine) Abstain(serverId uuid.UUID, privateId string) (VoteResult, error) {
+	return e.castVote(serverId, privateId, models.Abstention, "", "", true)
+}
+
+// castVote is Vote, or Abstain when abstain is set
+func (e *Engine) castVote(serverId uuid.UUID, privateId string, vote string, second string, confidence models.Confidence, abstain bool) (VoteResult, error) {
+	var result VoteResult
+
+	switch confidence {
+	case "", models.ConfidenceLow, models.ConfidenceMedium, models.ConfidenceHigh:
+	default:
+		return result, fmt.Errorf("%w %q", ErrInvalidConfidence, confidence)
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	server, ok := e.servers[serverId]
+	server, ok := e.room(serverId)
 	if !ok {
-		return errors.New("room not found")
+		return result, ErrRoomNotFound
 	}
 
+	// Only players in the room can vote, whatever the server let through,
+	// and nothing is touched until the vote is known to be valid
 	player, ok := server.Players[privateId]
 	if !ok {
-		return errors.New("player not found")
+		return result, ErrPlayerNotFound
 	}
 
 	if player.Type == models.Observer {
-		return errors.New("observers cannot vote")
+		return result, ErrObserverVote
 	}
 
-	if server.CurrentSession.IsShown {
-		return errors.New("cannot change vote once revealed")
+	session := server.Session(player.Track)
+	if session.IsShown {
+		return result, ErrRevealed
+	}
+
+	if session.Locked {
+		return result, ErrLocked
+	}
+
+	if session.Opening {
+		return result, ErrLocked
+	}
+
+	// A sealed vote can't be checked against the deck until it is opened
+	sealed := server.Settings.CommitReveal && !abstain
+	if sealed {
+		vote = strings.ToLower(vote)
+		if _, err := hex.DecodeString(vote); err != nil || len(vote) != 2*sha256.Size {
+			return result, ErrInvalidCommitment
+		}
+	} else if !abstain && !validVote(server, session, vote) {
+		return result, ErrInvalidVote
+	}
+
+	if second != "" && !slices.Contains(secondScale(server), second) {
+		return result, ErrInvalidSecondVote
 	}
 
 	player.Mode = models.Awake // If they vote, they are awake
-	server.CurrentSession.Votes[fmt.Sprintf("%d", player.PublicId)] = vote
+	result.PublicId = player.PublicId
+	key := fmt.Sprintf("%d", player.PublicId)
+	if prev, ok := session.Votes[key]; ok && prev != vote {
+		result.Changed = true
+	}
+	session.Votes[key] = vote
+	session.VotedAt[key] = time.Now()
+	if confidence != "" {
+		session.Confidence[key] = confidence
+	} else {
+		delete(session.Confidence, key)
+	}
+	if second != "" {
+		session.SecondVotes[key] = second
+	} else {
+		delete(session.SecondVotes, key)
+	}
+	if sealed {
+		session.Sealed[key] = true
+	} else {
+		delete(session.Sealed, key)
+	}
 	
-	metrics.PlayerActionsTotal.WithLabelValues("vote").Inc()
+	if abstain {
+		metrics.PlayerActionsTotal.WithLabelValues("abstain").Inc()
+	} else {
+		metrics.PlayerActionsTotal.WithLabelValues("vote").Inc()
+	}
+
+	if server.Settings.AutoReveal && autoRevealReached(server, player.Track) {
+		reveal(server, player.Track)
+		result.AutoRevealed = true
+		metrics.PlayerActionsTotal.WithLabelValues("autoReveal").Inc()
+		slog.Info("Votes auto-revealed", "roomId", serverId, "track", player.Track, "votes", len(session.Votes))
+	}
 	
-	return nil
+	return result, nil
+}
+
+// validVote reports whether a vote is on the session's cards, or an
+// acceptable custom vote in a room that allows them
+func validVote(server *models.PokerServer, session *models.PokerSession, vote string) bool {
+	if vote == models.Abstention {
+		return false // Only the abstain action casts it
+	}
+	if slices.Contains(session.CardSet, vote) {
+		return true
+	}
+	custom := strings.TrimSpace(vote) != "" && utf8.RuneCountInString(vote) <= maxCustomVoteLength
+	return server.Settings.AllowCustomVotes && custom
+}
+
+// newPrivateId returns a random private ID. Whoever holds it can act as the
+// player, so it comes from crypto/rand and is never derived from anything the
+// room can see, such as the player's address.
+func newPrivateId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
-func (e *Engine) UnVote(serverId uuid.UUID, privateId string) error {
+// commitment is what a voter in a commit-reveal room sends in place of their
+// vote: the hex SHA-256 of the vote, a colon and a nonce of their choosing
+func commitment(vote, nonce string) string {
+	sum := sha256.Sum256([]byte(vote + ":" + nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+// OpenVote replaces a player's sealed vote with the vote itself once their
+// track is being opened, checking it matches the commitment they cast. The
+// round is revealed when the last sealed vote is opened, which is reported.
+func (e *Engine) OpenVote(serverId uuid.UUID, privateId string, vote string, nonce string) (bool, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	server, ok := e.servers[serverId]
+	server, ok := e.room(serverId)
 	if !ok {
-		return errors.New("room not found")
-	}
-
-	if server.CurrentSession.IsShown {
-		return errors.New("cannot redact vote once revealed")
+		return false, ErrRoomNotFound
 	}
 
 	player, ok := server.Players[privateId]
 	if !ok {
-		return errors.New("player not found")
+		return false, ErrPlayerNotFound
 	}
 
-	player.Mode = models.Awake
-	delete(server.CurrentSession.Votes, fmt.Sprintf("%d", player.PublicId))
-	
-	metrics.PlayerActionsTotal.WithLabelValues("unvote").Inc()
-	
-	return nil
-}
+	session := server.Session(player.Track)
+	key := fmt.Sprintf("%d", player.PublicId)
+	if !session.Opening || !session.Sealed[key] {
+		return false, ErrNotOpening
+	}
+	if commitment(vote, nonce) != session.Votes[key] {
+		slog.Warn("Opened vote does not match its commitment", "roomId", serverId, "playerName", player.Name)
+		return false, ErrBadOpening
+	}
+	if !validVote(server, session, vote) {
+		return false, ErrInvalidVote
+	}
 
-func (e *Engine) ClearVotes(serverId uuid.UUID) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	session.Votes[key] = vote
+	delete(session.Sealed, key)
+	metrics.PlayerActionsTotal.WithLabelValues("openVote").Inc()
 
-	server, ok := e.servers[serverId]
-	if !ok {
-		return errors.New("room not found")
+	if len(session.Sealed) > 0 {
+		return false, nil
 	}
-
-	server.CurrentSession.Votes = make(map[string]string)
-	server.CurrentSession.IsShown = false
-	
-	metrics.PlayerActionsTotal.WithLabelValues("clear").Inc()
-	
-	return nil
+	reveal(server, player.Track)
+	return true, nil
 }
 
-func (e *Engine) ShowVotes(serverId uuid.UUID) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+// Opening reports whether the sealed votes in a player's track are waiting to
+// be opened
+func (e *Engine) Opening(serverId uuid.UUID, privateId string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
 	server, ok := e.servers[serverId]
 	if !ok {
-		return errors.New("room not found")
+		return false
 	}
+	return server.Session(trackOf(server, privateId)).Opening
+}
+
+// autoRevealReached reports whether enough of a track's awake participants
+// have voted to satisfy the room's auto-reveal ratio.
+func autoRevealReached(server *models.PokerServer, track string) bool {
+	eligible, voted := tally(server, track)
+	if eligible == 0 || voted < server.Settings.MinVotersToReveal {
+		return false
+	}
+	// Allow for float error so e.g. 0.8 of 5 needs exactly 4 votes
+	needed := int(math.Ceil(server.Settings.AutoRevealRatio*float64(eligible) - 1e-9))
+	return voted >= needed
+}
+
+// tally counts a track's awake participants and how many of them have voted.
+// Observers are left out either way. It is the one place that decides whose
+// vote a round waits for, for auto-reveal, progress and the minimum voters.
+func tally(server *models.PokerServer, track string) (eligible, voted int) {
+	session := server.Session(track)
+	for _, p := range server.Players {
+		if p.Type != models.Participant || p.Mode != models.Awake || p.Track != track {
+			continue
+		}
+		eligible++
+		if _, ok := session.Votes[fmt.Sprintf("%d", p.PublicId)]; ok {
+			voted++
+		}
+	}
+	return eligible, voted
+}
+
+// checkReveal reports why a track's round can't be revealed yet, if it can't.
+// Only the votes of those the round waits for count, as in tally.
+func checkReveal(server *models.PokerServer, track string) error {
+	eligible, voted := tally(server, track)
+	if voted == 0 && server.Settings.EmptyReveal == models.EmptyRevealBlock {
+		return ErrNoVotes
+	}
+	if needed := server.Settings.MinVotersToReveal; needed > 1 && voted < needed {
+		return &TooFewVotesError{Voted: voted, Needed: needed, Waiting: eligible - voted}
+	}
+	return nil
+}
+
+
+// UnVote withdraws a player's vote and returns their public ID
+func (e *Engine) UnVote(serverId uuid.UUID, privateId string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+
+	player, ok := server.Players[privateId]
+	if !ok {
+		return 0, ErrPlayerNotFound
+	}
+
+	session := server.Session(player.Track)
+	if session.IsShown {
+		return 0, ErrRevealed
+	}
+
+	if session.Locked || session.Opening {
+		return 0, ErrLocked
+	}
+
+	player.Mode = models.Awake
+	removeVote(server, player)
+	
+	metrics.PlayerActionsTotal.WithLabelValues("unvote").Inc()
+	
+	return player.PublicId, nil
+}
+
+// ClearVotes starts a new round in the caller's track. This also lifts any
+// voting lock, since the lock only applies to the round it was placed on.
+func (e *Engine) ClearVotes(serverId uuid.UUID, privateId string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !permitted(server, server.Settings.ResetPolicy, privateId) {
+		return ErrNotHost
+	}
+
+	resetRound(server.Session(trackOf(server, privateId)))
+	
+	metrics.PlayerActionsTotal.WithLabelValues("clear").Inc()
+	
+	return nil
+}
+
+// ShowVotes reveals the round in the caller's track and returns how many
+// votes were revealed.
+// Revealing an empty round is governed by the room's EmptyReveal policy.
+func (e *Engine) ShowVotes(serverId uuid.UUID, privateId string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+
+	if !permitted(server, server.Settings.RevealPolicy, privateId) {
+		return 0, ErrNotHost
+	}
+
+	track := trackOf(server, privateId)
+	if err := checkReveal(server, track); err != nil {
+		return 0, err
+	}
+
+	count := len(server.Session(track).Votes)
+	reveal(server, track)
+	
+	metrics.PlayerActionsTotal.WithLabelValues("show").Inc()
+	
+	return count, nil
+}
+
+// ResetAbandonedRound clears the round of a room no player is connected to,
+// keeping its decks and story queue. It reports whether there was anything
+// to clear, and does nothing if a player has reconnected in the meantime.
+func (e *Engine) ResetAbandonedRound(serverId uuid.UUID) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.servers[serverId]
+	if !ok {
+		return false
+	}
+	if e.Connected != nil && len(e.Connected(serverId)) > 0 {
+		return false
+	}
+	repairSession(server)
+
+	reset := false
+	for _, session := range sessions(server) {
+		if len(session.Votes) == 0 && !session.IsShown && !session.CountingDown && !session.Locked {
+			continue
+		}
+		resetRound(session)
+		reset = true
+	}
+	if reset {
+		slog.Info("Reset the round of an abandoned room", "roomId", serverId)
+	}
+
+	return reset
+}
+
+// HideVotes undoes an early reveal. The votes already cast are kept and
+// voting reopens, including after a countdown lock, so the round carries on.
+// A lock the host set themselves stays.
+// In an auto-reveal room the next vote that meets the threshold reveals again.
+func (e *Engine) HideVotes(serverId uuid.UUID, privateId string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return ErrNotHost
+	}
+
+	session := server.Session(trackOf(server, privateId))
+	if !session.IsShown {
+		return ErrNotRevealed
+	}
+
+	session.IsShown = false
+	if session.CountdownLock {
+		session.Locked = false
+		session.CountdownLock = false
+	}
+	session.Stats = nil
+	session.GroupStats = nil
+	session.SecondStats = nil
+	metrics.PlayerActionsTotal.WithLabelValues("hideVotes").Inc()
 
-	server.CurrentSession.IsShown = true
-	
-	metrics.PlayerActionsTotal.WithLabelValues("show").Inc()
-	
 	return nil
 }
 
-func (e *Engine) KickPlayer(serverId uuid.UUID, kickedPublicId int) (string, error) {
+// CreateTrack opens a parallel track in the room, so part of the team can
+// estimate something else at the same time. The track starts with the main
+// track's cards. It returns the cleaned track name.
+func (e *Engine) CreateTrack(serverId uuid.UUID, privateId string, name string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return "", ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return "", ErrNotHost
+	}
+
+	name, err := limitText(name, maxTrackNameLength, ErrInvalidTrackName)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", ErrInvalidTrackName
+	}
+	if _, exists := server.Tracks[name]; exists {
+		return "", ErrTrackExists
+	}
+	if len(server.Tracks) >= maxTracks {
+		return "", fmt.Errorf("a room can open at most %d tracks", maxTracks)
+	}
+
+	if server.Tracks == nil {
+		server.Tracks = make(map[string]*models.PokerSession)
+	}
+	server.Tracks[name] = models.NewSession(server.CurrentSession.CardSet, nil, server.CurrentSession.ActiveDeck)
+	metrics.PlayerActionsTotal.WithLabelValues("createTrack").Inc()
+	slog.Info("Track created", "roomId", serverId, "track", name)
+
+	return name, nil
+}
+
+// JoinTrack moves a player to the named track, or back to the main track when
+// the name is empty. Any vote in the track they leave is withdrawn, which can
+// move that track's round on by itself.
+func (e *Engine) JoinTrack(serverId uuid.UUID, privateId string, name string) (models.Player, RoundChange, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrRoomNotFound
+	}
+
+	player, ok := server.Players[privateId]
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrPlayerNotFound
+	}
+
+	if server.Session(name) == nil {
+		return models.Player{}, RoundUnchanged, ErrUnknownTrack
+	}
+
+	player.Mode = models.Awake
+	if player.Track == name {
+		return *player, RoundUnchanged, nil
+	}
+	left := player.Track
+	hadVote := removeVote(server, player)
+	player.Track = name
+	change := settleRound(server, left, hadVote)
+	metrics.PlayerActionsTotal.WithLabelValues("joinTrack").Inc()
+
+	return *player, change, nil
+}
+
+// PlayerTrack looks up the track a player is in
+func (e *Engine) PlayerTrack(serverId uuid.UUID, privateId string) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok {
+		return ""
+	}
+	return trackOf(server, privateId)
+}
+
+// Progress tallies the votes in the track a player is in
+func (e *Engine) Progress(serverId uuid.UUID, privateId string) (models.ProgressMessage, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok {
+		return models.ProgressMessage{}, false
+	}
+	track := trackOf(server, privateId)
+	total, voted := tally(server, track)
+	return models.ProgressMessage{Track: track, Voted: voted, Total: total}, true
+}
+
+// StartCountdown begins a reveal countdown and returns its length in seconds.
+// A room without a countdown returns 0 and the caller should reveal at once.
+func (e *Engine) StartCountdown(serverId uuid.UUID, privateId string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+
+	if !permitted(server, server.Settings.RevealPolicy, privateId) {
+		return 0, ErrNotHost
+	}
+
+	// Only the main track counts down, the others reveal at once
+	if trackOf(server, privateId) != "" {
+		return 0, nil
+	}
+
+	if server.CurrentSession.IsShown {
+		return 0, ErrRevealed
+	}
+
+	if server.CurrentSession.CountingDown {
+		return 0, ErrCountdownRunning
+	}
+
+	if err := checkReveal(server, ""); err != nil {
+		return 0, err
+	}
+
+	seconds := server.Settings.RevealCountdown
+	if seconds == 0 {
+		return 0, nil
+	}
+
+	server.CurrentSession.CountingDown = true
+	if server.Settings.LockOnCountdown && !server.CurrentSession.Locked {
+		server.CurrentSession.Locked = true
+		server.CurrentSession.CountdownLock = true
+	}
+	metrics.PlayerActionsTotal.WithLabelValues("countdown").Inc()
+
+	return seconds, nil
+}
+
+// CountingDown reports whether a reveal countdown is still running in the room
+func (e *Engine) CountingDown(serverId uuid.UUID) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	return ok && server.Session("").CountingDown
+}
+
+// FinishCountdown reveals the round at the end of a countdown. It returns
+// ErrNoCountdown if the countdown was cancelled by a clear or a manual reveal.
+func (e *Engine) FinishCountdown(serverId uuid.UUID) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+
+	if !server.CurrentSession.CountingDown {
+		return 0, ErrNoCountdown
+	}
+	server.CurrentSession.CountingDown = false
+
+	// Votes withdrawn during the countdown can leave too few to reveal, in
+	// which case voting reopens rather than staying locked
+	if err := checkReveal(server, ""); err != nil {
+		if server.CurrentSession.CountdownLock {
+			server.CurrentSession.Locked = false
+			server.CurrentSession.CountdownLock = false
+		}
+		return 0, err
+	}
+
+	count := len(server.CurrentSession.Votes)
+	reveal(server, "")
+
+	metrics.PlayerActionsTotal.WithLabelValues("show").Inc()
+
+	return count, nil
+}
+
+// AutoClearDue reports how long the room's revealed main round has left before
+// it is cleared automatically, along with when it was revealed, which
+// identifies the round to AutoClear. ok is false when the room doesn't clear
+// rounds by itself or its main round isn't revealed.
+func (e *Engine) AutoClearDue(serverId uuid.UUID) (delay time.Duration, revealedAt time.Time, ok bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok || server.Settings.AutoClearAfter == 0 {
+		return 0, time.Time{}, false
+	}
+	session := server.Session("")
+	if !session.IsShown {
+		return 0, time.Time{}, false
+	}
+	delay = time.Until(session.RevealedAt.Add(time.Duration(server.Settings.AutoClearAfter) * time.Second))
+	return max(delay, 0), session.RevealedAt, true
+}
+
+// AutoClear clears the main round revealed at revealedAt and brings up the
+// next story, which it returns, nil if the queue has no more. It reports false
+// and does nothing if the round was hidden or cleared in the meantime,
+// including when it has since been revealed again, or if the room has stopped
+// clearing rounds by itself.
+func (e *Engine) AutoClear(serverId uuid.UUID, revealedAt time.Time) (*models.Story, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return nil, false
+	}
+
+	session := server.CurrentSession
+	if server.Settings.AutoClearAfter == 0 || !session.IsShown || !session.RevealedAt.Equal(revealedAt) {
+		return nil, false
+	}
+
+	resetRound(session)
+	metrics.PlayerActionsTotal.WithLabelValues("autoClear").Inc()
+
+	if server.StoryIndex+1 >= len(server.Stories) {
+		return nil, true
+	}
+	server.StoryIndex++
+	next := server.Stories[server.StoryIndex]
+	return &next, true
+}
+
+// UpdateSettings lets the host change room settings after creation. Only the
+// fields present in the update are changed.
+func (e *Engine) UpdateSettings(serverId uuid.UUID, privateId string, update models.SettingsUpdate) (models.RoomSettings, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.RoomSettings{}, ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return models.RoomSettings{}, ErrNotHost
+	}
+
+	settings := update.Apply(server.Settings)
+	if err := validateSettings(&settings); err != nil {
+		return models.RoomSettings{}, err
+	}
+	if err := checkSecondScale(settings, server.CurrentSession.Decks); err != nil {
+		return models.RoomSettings{}, err
+	}
+
+	server.Settings = settings
+	metrics.PlayerActionsTotal.WithLabelValues("updateSettings").Inc()
+	slog.Info("Room settings updated", "roomId", serverId)
+
+	return settings, nil
+}
+
+// SetLocked lets the host freeze or unfreeze voting without revealing
+func (e *Engine) SetLocked(serverId uuid.UUID, privateId string, locked bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return ErrNotHost
+	}
+
+	server.CurrentSession.Locked = locked
+	server.CurrentSession.CountdownLock = false
+	metrics.PlayerActionsTotal.WithLabelValues("lockVoting").Inc()
+
+	return nil
+}
+
+// ChangeDeck switches the active deck. Votes are cleared because they were
+// cast against the old deck's values.
+func (e *Engine) ChangeDeck(serverId uuid.UUID, privateId string, deck string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return ErrNotHost
+	}
+
+	cards, ok := server.CurrentSession.Decks[deck]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownDeck, deck)
+	}
+
+	server.CurrentSession.ActiveDeck = deck
+	server.CurrentSession.CardSet = append([]string(nil), cards...)
+	resetRound(server.CurrentSession)
+
+	metrics.PlayerActionsTotal.WithLabelValues("changeDeck").Inc()
+	slog.Info("Deck changed", "roomId", serverId, "deck", deck)
+
+	return nil
+}
+
+// EditDeck replaces the cards of the active deck with a comma-separated card
+// set or preset name, returning the new cards. Votes are cleared because they
+// may not be on the new cards.
+func (e *Engine) EditDeck(serverId uuid.UUID, privateId string, cardSet string) ([]string, error) {
+	cards := parseCardSet(cardSet)
+	if len(cards) == 0 {
+		return nil, ErrEmptyDeck
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return nil, ErrNotHost
+	}
+
+	session := server.CurrentSession
+	session.Decks[session.ActiveDeck] = cards
+	session.CardSet = append([]string(nil), cards...)
+	resetRound(session)
+
+	metrics.PlayerActionsTotal.WithLabelValues("editDeck").Inc()
+	slog.Info("Deck edited", "roomId", serverId, "deck", session.ActiveDeck, "cards", len(cards))
+
+	return append([]string(nil), cards...), nil
+}
+
+// AddStories appends stories to the room's estimation queue and returns the
+// new queue length.
+func (e *Engine) AddStories(serverId uuid.UUID, stories []models.Story) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return 0, ErrRoomNotFound
+	}
+
+	if len(stories) == 0 {
+		return 0, errors.New("no stories given")
+	}
+	if len(server.Stories)+len(stories) > maxStories {
+		return 0, fmt.Errorf("a room can hold at most %d stories", maxStories)
+	}
+
+	for i := range stories {
+		stories[i].Title = strings.TrimSpace(stories[i].Title)
+		stories[i].Description = strings.TrimSpace(stories[i].Description)
+		stories[i].IssueKey = strings.TrimSpace(stories[i].IssueKey)
+		stories[i].URL = strings.TrimSpace(stories[i].URL)
+		if stories[i].Title == "" {
+			return 0, fmt.Errorf("story %d has no title", i+1)
+		}
+	}
+
+	server.Stories = append(server.Stories, stories...)
+	metrics.PlayerActionsTotal.WithLabelValues("addStories").Inc()
+	slog.Info("Stories added", "roomId", serverId, "added", len(stories), "total", len(server.Stories))
+
+	return len(server.Stories), nil
+}
+
+// MoveStory lets the host step through the queue by delta positions, clearing
+// the round so the team votes afresh on the new story.
+func (e *Engine) MoveStory(serverId uuid.UUID, privateId string, delta int) (models.Story, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Story{}, ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return models.Story{}, ErrNotHost
+	}
+
+	index := server.StoryIndex + delta
+	if index < 0 || index >= len(server.Stories) {
+		return models.Story{}, ErrNoStory
+	}
+
+	server.StoryIndex = index
+	resetRound(server.CurrentSession)
+	metrics.PlayerActionsTotal.WithLabelValues("moveStory").Inc()
+
+	return server.Stories[index], nil
+}
+
+// SkipStory marks the active story as skipped, with no estimate, and moves
+// on to the next one with a fresh round. It returns the skipped story and the
+// next one, which is nil when the skipped story was the last in the queue.
+func (e *Engine) SkipStory(serverId uuid.UUID, privateId string) (models.Story, *models.Story, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Story{}, nil, ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return models.Story{}, nil, ErrNotHost
+	}
+
+	if server.StoryIndex >= len(server.Stories) {
+		return models.Story{}, nil, ErrNoActiveStory
+	}
+
+	story := &server.Stories[server.StoryIndex]
+	story.FinalEstimate = ""
+	story.Skipped = true
+	skipped := *story
+
+	resetRound(server.CurrentSession)
+	metrics.PlayerActionsTotal.WithLabelValues("skipStory").Inc()
+
+	if server.StoryIndex+1 >= len(server.Stories) {
+		return skipped, nil, nil
+	}
+	server.StoryIndex++
+	next := server.Stories[server.StoryIndex]
+	return skipped, &next, nil
+}
+
+// SetEstimate records the team's agreed value on the active story. The value
+// must be a card from the active deck unless allowCustom is set, in which case
+// any short free-form value is accepted. An empty estimate clears it.
+func (e *Engine) SetEstimate(serverId uuid.UUID, privateId string, estimate string, allowCustom bool) (models.Story, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Story{}, ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return models.Story{}, ErrNotHost
+	}
+
+	if server.StoryIndex >= len(server.Stories) {
+		return models.Story{}, ErrNoActiveStory
+	}
+
+	estimate = strings.TrimSpace(estimate)
+	if estimate != "" {
+		if allowCustom {
+			if utf8.RuneCountInString(estimate) > maxEstimateLength {
+				return models.Story{}, fmt.Errorf("estimate cannot be longer than %d characters", maxEstimateLength)
+			}
+		} else if !slices.Contains(server.CurrentSession.CardSet, estimate) {
+			return models.Story{}, ErrInvalidEstimate
+		}
+	}
+
+	story := &server.Stories[server.StoryIndex]
+	story.FinalEstimate = estimate
+	if estimate != "" {
+		story.Skipped = false
+	}
+	metrics.PlayerActionsTotal.WithLabelValues("setEstimate").Inc()
+
+	return *story, nil
+}
+
+// ChangePlayerType switches a player between participant and observer. A new
+// observer's vote is withdrawn in the same critical section so no one can see
+// an observer holding a vote.
+func (e *Engine) ChangePlayerType(serverId uuid.UUID, privateId string, pType models.PlayerType) (models.Player, RoundChange, error) {
+	if pType != models.Participant && pType != models.Observer {
+		return models.Player{}, RoundUnchanged, fmt.Errorf("%w %q", ErrInvalidPlayerType, pType)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrRoomNotFound
+	}
+
+	player, ok := server.Players[privateId]
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrPlayerNotFound
+	}
+
+	player.Type = pType
+	change := RoundUnchanged
+	if pType == models.Observer {
+		change = settleRound(server, player.Track, removeVote(server, player))
+	}
+	metrics.PlayerActionsTotal.WithLabelValues("changeType").Inc()
+
+	return *player, change, nil
+}
+
+// UpdatePlayer renames the player and changes their type and group. Empty
+// values are left unchanged, and becoming an observer withdraws any vote.
+func (e *Engine) UpdatePlayer(serverId uuid.UUID, privateId string, name string, pType models.PlayerType, group string) (models.Player, RoundChange, error) {
+	if pType != "" && pType != models.Participant && pType != models.Observer {
+		return models.Player{}, RoundUnchanged, fmt.Errorf("%w %q", ErrInvalidPlayerType, pType)
+	}
+	group, err := cleanGroup(group)
+	if err != nil {
+		return models.Player{}, RoundUnchanged, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrRoomNotFound
+	}
+
+	player, ok := server.Players[privateId]
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrPlayerNotFound
+	}
+
+	name, err = cleanName(name, server.Settings)
+	if err != nil {
+		return models.Player{}, RoundUnchanged, err
+	}
+	if name != "" {
+		player.Name = name
+	}
+	change := RoundUnchanged
+	if pType != "" {
+		player.Type = pType
+		if pType == models.Observer {
+			change = settleRound(server, player.Track, removeVote(server, player))
+		}
+	}
+	if group != "" {
+		player.Group = group
+	}
+	player.Mode = models.Awake
+
+	return *player, change, nil
+}
+
+// ChatOnly reports whether the room limits the player to chatting, because
+// they are an observer in a room with ObserversChatOnly set. The host is
+// never limited, as a facilitator often hosts as an observer.
+func (e *Engine) ChatOnly(serverId uuid.UUID, privateId string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok || !server.Settings.ObserversChatOnly {
+		return false
+	}
+	player, ok := server.Players[privateId]
+	return ok && player.Type == models.Observer && !isHost(server, privateId)
+}
+
+// PlayerName looks up a player's display name
+func (e *Engine) PlayerName(serverId uuid.UUID, privateId string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok {
+		return "", false
+	}
+	player, ok := server.Players[privateId]
+	if !ok {
+		return "", false
+	}
+	return player.Name, true
+}
+
+// ForceObserver lets the host turn a participant into an observer without
+// removing them. It returns a copy of the updated player so the server can
+// tell them about their new type.
+func (e *Engine) ForceObserver(serverId uuid.UUID, hostPrivateId string, targetPublicId int) (models.Player, RoundChange, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.Player{}, RoundUnchanged, ErrRoomNotFound
+	}
+
+	if !isHost(server, hostPrivateId) {
+		return models.Player{}, RoundUnchanged, ErrNotHost
+	}
+
+	for _, p := range server.Players {
+		if p.PublicId == targetPublicId {
+			p.Type = models.Observer
+			change := settleRound(server, p.Track, removeVote(server, p))
+
+			metrics.PlayerActionsTotal.WithLabelValues("forceObserver").Inc()
+			slog.Info("Player forced to observer", "roomId", serverId, "publicId", targetPublicId, "playerName", p.Name)
+
+			return *p, change, nil
+		}
+	}
+
+	return models.Player{}, RoundUnchanged, ErrPlayerNotFound
+}
+
+// AddChat cleans a chat message and records it in the room's bounded
+// history, returning the message as it should be broadcast
+func (e *Engine) AddChat(serverId uuid.UUID, message models.ChatMessage) (models.ChatMessage, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return models.ChatMessage{}, ErrRoomNotFound
+	}
+	if !server.Settings.ChatEnabled {
+		return models.ChatMessage{}, ErrChatDisabled
+	}
+
+	text, err := limitText(message.Message, server.Settings.MaxChatLength, ErrInvalidChat)
+	if err != nil {
+		return models.ChatMessage{}, err
+	}
+	if text == "" {
+		return models.ChatMessage{}, ErrInvalidChat
+	}
+	message.Message = text
+
+	server.ChatHistory = append(server.ChatHistory, message)
+	if len(server.ChatHistory) > maxChatHistory {
+		server.ChatHistory = server.ChatHistory[len(server.ChatHistory)-maxChatHistory:]
+	}
+	return message, nil
+}
+
+func (e *Engine) ChatHistory(serverId uuid.UUID) []models.ChatMessage {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok {
+		return nil
+	}
+	return append([]models.ChatMessage(nil), server.ChatHistory...)
+}
+
+// AddLog records an entry in the room's bounded activity log
+func (e *Engine) AddLog(serverId uuid.UUID, entry models.LogMessage) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	server, ok := e.servers[serverId]
 	if !ok {
-		return "", errors.New("room not found")
+		return
+	}
+
+	server.ActivityLog = append(server.ActivityLog, entry)
+	if len(server.ActivityLog) > maxActivityLog {
+		server.ActivityLog = server.ActivityLog[len(server.ActivityLog)-maxActivityLog:]
+	}
+}
+
+// ActivityLog returns the room's recent log entries, oldest first
+func (e *Engine) ActivityLog(serverId uuid.UUID) ([]models.LogMessage, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	server, ok := e.servers[serverId]
+	if !ok {
+		return nil, false
+	}
+	return append([]models.LogMessage{}, server.ActivityLog...), true
+}
+
+func (e *Engine) ClearChat(serverId uuid.UUID, privateId string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return ErrNotHost
+	}
+
+	server.ChatHistory = nil
+	metrics.PlayerActionsTotal.WithLabelValues("clearChat").Inc()
+
+	return nil
+}
+
+// PinNote sets the note the host keeps in view for everyone in the room. An
+// empty note unpins it. It returns the cleaned note.
+func (e *Engine) PinNote(serverId uuid.UUID, privateId string, note string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return "", ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return "", ErrNotHost
+	}
+
+	note, err := limitText(note, maxPinnedNoteLength, ErrNoteTooLong)
+	if err != nil {
+		return "", err
+	}
+
+	server.PinnedNote = note
+	metrics.PlayerActionsTotal.WithLabelValues("pinNote").Inc()
+
+	return note, nil
+}
+
+// ResetRoom returns the room to how it was when created, so it can be reused
+// for a new session: the story queue, chat, activity log, tracks and votes
+// all go. Players, settings and decks stay, and everyone is in the main track.
+func (e *Engine) ResetRoom(serverId uuid.UUID, privateId string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	if !isHost(server, privateId) {
+		return ErrNotHost
+	}
+
+	resetRound(server.CurrentSession)
+	server.Tracks = nil
+	for _, p := range server.Players {
+		p.Track = ""
+	}
+	server.Stories = nil
+	server.StoryIndex = 0
+	server.ChatHistory = nil
+	server.ActivityLog = nil
+	server.PinnedNote = ""
+	metrics.PlayerActionsTotal.WithLabelValues("resetRoom").Inc()
+	slog.Info("Room reset", "roomId", serverId)
+
+	return nil
+}
+
+// KickPlayer removes a player at the host's request and keeps them out for the
+// room's kick cooldown. It returns the kicked player's private ID so the server
+// can tell them.
+func (e *Engine) KickPlayer(serverId uuid.UUID, hostPrivateId string, kickedPublicId int) (string, RoundChange, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return "", RoundUnchanged, ErrRoomNotFound
+	}
+
+	if !isHost(server, hostPrivateId) {
+		return "", RoundUnchanged, ErrNotHost
 	}
 
 	for id, p := range server.Players {
 		if p.PublicId == kickedPublicId {
-			delete(server.Players, id)
-			delete(server.CurrentSession.Votes, fmt.Sprintf("%d", p.PublicId))
-			
-			metrics.ActivePlayers.Dec()
+			change := e.kick(server, id, p)
 			slog.Info("Player kicked", "roomId", serverId, "publicId", kickedPublicId, "playerName", p.Name)
 			
-			return id, nil
+			return id, change, nil
+		}
+	}
+
+	return "", RoundUnchanged, ErrPlayerNotFound
+}
+
+// kick removes a player from the room and bans them for the kick cooldown.
+// Their vote goes and the host and round move on as for any departure. Any
+// pending disconnect is dropped, so it can't fire for a player who has gone.
+// The caller must hold the engine lock.
+func (e *Engine) kick(server *models.PokerServer, privateId string, player *models.Player) RoundChange {
+	delete(server.Players, privateId)
+	if pending, ok := e.pending[player.RecoveryId]; ok && pending.roomId == server.Id {
+		pending.timer.Stop()
+		delete(e.pending, player.RecoveryId)
+	}
+	hadVote := removeVote(server, player)
+	reassignHost(server)
+	change := settleRound(server, player.Track, hadVote)
+	ban(server, player)
+
+	metrics.ActivePlayers.Dec()
+	return change
+}
+
+// KickObservers kicks every observer except the host who asked for it, as
+// KickPlayer would each of them. It returns their private IDs so the server
+// can tell each of them, and how the round moved on.
+func (e *Engine) KickObservers(serverId uuid.UUID, hostPrivateId string) ([]string, RoundChange, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return nil, RoundUnchanged, ErrRoomNotFound
+	}
+
+	if !isHost(server, hostPrivateId) {
+		return nil, RoundUnchanged, ErrNotHost
+	}
+
+	var kicked []string
+	change := RoundUnchanged
+	for id, p := range server.Players {
+		if p.Type != models.Observer || id == hostPrivateId {
+			continue
+		}
+		if c := e.kick(server, id, p); c != RoundUnchanged {
+			change = c
 		}
+		kicked = append(kicked, id)
 	}
 
-	return "", errors.New("player not found")
+	metrics.PlayerActionsTotal.WithLabelValues("kickObservers").Inc()
+	slog.Info("Observers kicked", "roomId", serverId, "count", len(kicked))
+
+	return kicked, change, nil
 }
 
-func (e *Engine) DisconnectPlayer(serverId uuid.UUID, privateId string) (string, bool) {
+// DisconnectPlayer marks a player asleep and returns a copy of them. Asleep
+// players don't count towards auto-reveal, so this can reveal the round.
+func (e *Engine) DisconnectPlayer(serverId uuid.UUID, privateId string) (models.Player, RoundChange, bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	server, ok := e.servers[serverId]
 	if !ok {
-		return "", false
+		return models.Player{}, RoundUnchanged, false
 	}
 
 	player, ok := server.Players[privateId]
 	if !ok {
-		return "", false
+		return models.Player{}, RoundUnchanged, false
 	}
+	repairSession(server)
 
 	player.Mode = models.Asleep
 	slog.Info("Player marked asleep", "roomId", serverId, "playerName", player.Name)
-	return player.Name, true
+	return *player, settleRound(server, player.Track, false), true
 }
 
-func (e *Engine) LeaveRoom(serverId uuid.UUID, privateId string) (string, bool) {
+// DisconnectAfterGrace starts the reconnect grace period for a player whose
+// connection dropped, and calls disconnect once it is over unless they have
+// reconnected with their recovery ID in the meantime. With no grace period,
+// disconnect is called straight away.
+func (e *Engine) DisconnectAfterGrace(serverId uuid.UUID, privateId string, disconnect func()) {
+	if e.ReconnectGrace <= 0 {
+		disconnect()
+		return
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	server, ok := e.servers[serverId]
 	if !ok {
+		return
+	}
+	player, ok := server.Players[privateId]
+	if !ok {
+		return
+	}
+
+	recoveryId := player.RecoveryId
+	if earlier, ok := e.pending[recoveryId]; ok {
+		earlier.timer.Stop()
+	}
+	pending := &pendingDisconnect{roomId: serverId, privateId: privateId}
+	pending.timer = time.AfterFunc(e.ReconnectGrace, func() {
+		e.mu.Lock()
+		current := e.pending[recoveryId] == pending
+		if current {
+			delete(e.pending, recoveryId)
+		}
+		e.mu.Unlock()
+		if current {
+			disconnect()
+		}
+	})
+	e.pending[recoveryId] = pending
+	slog.Debug("Player connection dropped, waiting for a reconnect", "roomId", serverId, "playerName", player.Name)
+}
+
+// CancelDisconnect stops the pending disconnect of a player reconnecting
+// within the grace period. It returns the private ID they dropped under, and
+// reports whether a disconnect was pending.
+func (e *Engine) CancelDisconnect(serverId uuid.UUID, recoveryId uuid.UUID) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pending, ok := e.pending[recoveryId]
+	if !ok || pending.roomId != serverId {
 		return "", false
 	}
+	pending.timer.Stop()
+	delete(e.pending, recoveryId)
+	return pending.privateId, true
+}
+
+func (e *Engine) LeaveRoom(serverId uuid.UUID, privateId string) (string, RoundChange, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	server, ok := e.room(serverId)
+	if !ok {
+		return "", RoundUnchanged, false
+	}
 
 	player, ok := server.Players[privateId]
 	if !ok {
-		return "", false
+		return "", RoundUnchanged, false
 	}
 
 	name := player.Name
 	delete(server.Players, privateId)
-	delete(server.CurrentSession.Votes, fmt.Sprintf("%d", player.PublicId))
+	hadVote := removeVote(server, player)
+	reassignHost(server)
+	change := settleRound(server, player.Track, hadVote)
 	
 	metrics.ActivePlayers.Dec()
 	slog.Info("Player left room", "roomId", serverId, "playerName", name)
 
-	return name, true
+	return name, change, true
+}
+
+// reveal shows a track's round and computes its stats
+func reveal(server *models.PokerServer, track string) {
+	session := server.Session(track)
+	// Sealed votes are first handed back to their voters to open. Revealing
+	// again while they are being opened gives up on the votes still sealed.
+	if len(session.Sealed) > 0 {
+		if !session.Opening {
+			session.Opening = true
+			session.CountingDown = false
+			return
+		}
+		for key := range session.Sealed {
+			delete(session.Votes, key)
+			delete(session.Confidence, key)
+			delete(session.VotedAt, key)
+			delete(session.SecondVotes, key)
+		}
+		session.Sealed = make(map[string]bool)
+	}
+	session.Opening = false
+	computeStats(server, track)
+	session.IsShown = true
+	session.CountingDown = false
+	session.RevealedAt = time.Now()
+}
+
+// computeStats works out the stats of a track's votes. A revealed round's
+// stats are recomputed when its votes change, which leaves the reveal itself,
+// and so when it happened, alone.
+func computeStats(server *models.PokerServer, track string) {
+	session := server.Session(track)
+	stats := ComputeStats(countedVotes(server, session), session.CardSet, server.Settings.SpreadThreshold, server.Settings.EstimateUnit)
+	session.Stats = &stats
+	session.GroupStats = groupStats(server, track)
+	session.SecondStats = nil
+	if cards := secondScale(server); cards != nil {
+		second := make(map[string]string)
+		for key := range countedVotes(server, session) {
+			if value, ok := session.SecondVotes[key]; ok {
+				second[key] = value
+			}
+		}
+		stats := ComputeStats(second, cards, server.Settings.SpreadThreshold, models.UnitPoints)
+		session.SecondStats = &stats
+	}
+}
+
+// resetRound clears the votes and reveal state of a session's round
+func resetRound(session *models.PokerSession) {
+	session.Votes = make(map[string]string)
+	session.Confidence = make(map[string]models.Confidence)
+	session.VotedAt = make(map[string]time.Time)
+	session.SecondVotes = make(map[string]string)
+	session.Sealed = make(map[string]bool)
+	session.Opening = false
+	session.IsShown = false
+	session.Locked = false
+	session.CountdownLock = false
+	session.CountingDown = false
+	session.Stats = nil
+	session.GroupStats = nil
+	session.SecondStats = nil
+	session.StartedAt = time.Now()
+	session.RevealedAt = time.Time{}
+}
+
+// secondScale returns the cards of the room's second scale, nil in a
+// single-scale room
+func secondScale(server *models.PokerServer) []string {
+	if server.Settings.SecondScale == "" {
+		return nil
+	}
+	return server.CurrentSession.Decks[server.Settings.SecondScale]
+}
+
+// checkSecondScale makes sure a room's second scale is one of its decks
+func checkSecondScale(settings models.RoomSettings, decks map[string][]string) error {
+	if settings.SecondScale == "" {
+		return nil
+	}
+	if _, ok := decks[settings.SecondScale]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownDeck, settings.SecondScale)
+	}
+	return nil
+}
+
+// countedVotes returns the session's votes that go into its stats. Becoming
+// an observer withdraws a vote, but a vote whose owner is an observer by the
+// time of the reveal is left out regardless, whatever order things happened in.
+func countedVotes(server *models.PokerServer, session *models.PokerSession) map[string]string {
+	observers := make(map[string]bool)
+	for _, p := range server.Players {
+		if p.Type == models.Observer {
+			observers[fmt.Sprintf("%d", p.PublicId)] = true
+		}
+	}
+	if len(observers) == 0 {
+		return session.Votes
+	}
+	votes := make(map[string]string, len(session.Votes))
+	for key, vote := range session.Votes {
+		if !observers[key] {
+			votes[key] = vote
+		}
+	}
+	return votes
+}
+
+// sessions returns the room's main session followed by those of its tracks
+func sessions(server *models.PokerServer) []*models.PokerSession {
+	all := []*models.PokerSession{server.Session("")}
+	for _, session := range server.Tracks {
+		all = append(all, session)
+	}
+	return all
+}
+
+// trackOf returns the track a player is in. Callers who aren't players act
+// on the main track.
+func trackOf(server *models.PokerServer, privateId string) string {
+	if player, ok := server.Players[privateId]; ok {
+		return player.Track
+	}
+	return ""
+}
+
+// groupStats breaks a track's votes down by player group. Rooms where nobody
+// has a group get nil, since the breakdown would just repeat the overall stats.
+func groupStats(server *models.PokerServer, track string) map[string]*models.Stats {
+	session := server.Session(track)
+	grouped := false
+	votes := make(map[string]map[string]string)
+	for _, p := range server.Players {
+		grouped = grouped || p.Group != ""
+		key := fmt.Sprintf("%d", p.PublicId)
+		vote, ok := session.Votes[key]
+		if !ok || p.Type == models.Observer {
+			continue
+		}
+		group := p.Group
+		if group == "" {
+			group = models.DefaultGroup
+		}
+		if votes[group] == nil {
+			votes[group] = make(map[string]string)
+		}
+		votes[group][key] = vote
+	}
+	if !grouped {
+		return nil
+	}
+
+	stats := make(map[string]*models.Stats, len(votes))
+	for group, groupVotes := range votes {
+		s := ComputeStats(groupVotes, session.CardSet, server.Settings.SpreadThreshold, server.Settings.EstimateUnit)
+		stats[group] = &s
+	}
+	return stats
+}
+
+// ban keeps a kicked player from rejoining with their recovery ID, or from
+// the address they were connected from, until the room's kick cooldown is
+// over. Expired bans are pruned as new ones are added.
+func ban(server *models.PokerServer, player *models.Player) {
+	if server.Settings.KickCooldown == 0 {
+		return
+	}
+	now := time.Now()
+	until := now.Add(time.Duration(server.Settings.KickCooldown) * time.Second)
+	if server.Bans == nil {
+		server.Bans = make(map[uuid.UUID]time.Time)
+	}
+	for id, expiry := range server.Bans {
+		if now.After(expiry) {
+			delete(server.Bans, id)
+		}
+	}
+	server.Bans[player.RecoveryId] = until
+
+	host := addrHost(player.RemoteAddr)
+	if host == "" {
+		return
+	}
+	if server.AddrBans == nil {
+		server.AddrBans = make(map[string]time.Time)
+	}
+	for addr, expiry := range server.AddrBans {
+		if now.After(expiry) {
+			delete(server.AddrBans, addr)
+		}
+	}
+	server.AddrBans[host] = until
+}
+
+// hasRecoveryId reports whether a player in the room holds the recovery ID
+func hasRecoveryId(server *models.PokerServer, recoveryId uuid.UUID) bool {
+	for _, p := range server.Players {
+		if p.RecoveryId == recoveryId {
+			return true
+		}
+	}
+	return false
+}
+
+// addrHost strips the port from a remote address, since a reconnect comes
+// from a new one
+func addrHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// cleanText trims free text shown to other players and strips invalid UTF-8
+// and control characters other than newlines
+func cleanText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, ""))
+	return strings.TrimSpace(text)
+}
+
+// limitText cleans free text and checks it against one of the room's length
+// limits, wrapping err with the limit when it is too long
+func limitText(text string, limit int, err error) (string, error) {
+	text = cleanText(text)
+	if utf8.RuneCountInString(text) > limit {
+		return "", fmt.Errorf("%w, the limit is %d characters", err, limit)
+	}
+	return text, nil
+}
+
+// cleanName cleans a player name and checks it against the room's limit
+func cleanName(name string, settings models.RoomSettings) (string, error) {
+	return limitText(name, settings.MaxNameLength, ErrNameTooLong)
+}
+
+// cleanGroup trims a player group name and checks its length
+func cleanGroup(group string) (string, error) {
+	group = strings.TrimSpace(group)
+	if utf8.RuneCountInString(group) > maxGroupLength {
+		return "", fmt.Errorf("group names are limited to %d characters", maxGroupLength)
+	}
+	return group, nil
+}
+
+// removeVote drops a player's vote from their track, keeping revealed stats
+// in step, and reports whether they had one
+func removeVote(server *models.PokerServer, player *models.Player) bool {
+	return removeTrackVote(server, player.Track, player.PublicId)
+}
+
+// removeTrackVote is removeVote for whoever holds the public ID in a track
+func removeTrackVote(server *models.PokerServer, track string, publicId int) bool {
+	session := server.Session(track)
+	key := fmt.Sprintf("%d", publicId)
+	if _, ok := session.Votes[key]; !ok {
+		return false
+	}
+	delete(session.Votes, key)
+	delete(session.Confidence, key)
+	delete(session.VotedAt, key)
+	delete(session.SecondVotes, key)
+	delete(session.Sealed, key)
+	// Whoever leaves last while the round is being opened leaves it ready
+	if session.IsShown {
+		computeStats(server, track)
+	} else if session.Opening && len(session.Sealed) == 0 {
+		reveal(server, track)
+	}
+	return true
+}
+
+// clearStaleVotes removes any vote still held under a public ID that is being
+// handed to a new player. Public IDs are reused once their players are gone,
+// and a vote left behind would otherwise be taken as the new player's.
+func clearStaleVotes(server *models.PokerServer, publicId int) {
+	tracks := []string{""}
+	for name := range server.Tracks {
+		tracks = append(tracks, name)
+	}
+	for _, track := range tracks {
+		if removeTrackVote(server, track, publicId) {
+			slog.Warn("Cleared stale vote for reused public ID", "roomId", server.Id, "publicId", publicId, "track", track)
+		}
+	}
+}
+
+// RoundChange is how the round moved on by itself after players stopped
+// taking part in it
+type RoundChange int
+
+const (
+	RoundUnchanged    RoundChange = iota
+	RoundAutoRevealed             // The remaining votes met the auto-reveal threshold
+	RoundReset                    // The round's last vote was removed, so a new round began
+)
+
+// settleRound brings a track's round up to date after a player leaves it, is
+// removed, stops participating or falls asleep. removedVote says whether they
+// took a vote with them.
+func settleRound(server *models.PokerServer, track string, removedVote bool) RoundChange {
+	session := server.Session(track)
+	if removedVote && len(session.Votes) == 0 {
+		resetRound(session)
+		// Clients take a clear to be for the main track, so other tracks
+		// start over quietly and show it in the next update
+		if track != "" {
+			return RoundUnchanged
+		}
+		return RoundReset
+	}
+	// A running countdown reveals by itself
+	if session.IsShown || session.CountingDown || len(session.Votes) == 0 {
+		return RoundUnchanged
+	}
+	if server.Settings.AutoReveal && autoRevealReached(server, track) {
+		reveal(server, track)
+		metrics.PlayerActionsTotal.WithLabelValues("autoReveal").Inc()
+		slog.Info("Votes auto-revealed", "roomId", server.Id, "track", track, "votes", len(session.Votes))
+		return RoundAutoRevealed
+	}
+	return RoundUnchanged
+}
+
+func isHost(server *models.PokerServer, privateId string) bool {
+	player, ok := server.Players[privateId]
+	return ok && player.PublicId == server.HostId
+}
+
+// permitted reports whether the player may perform an action governed by policy
+func permitted(server *models.PokerServer, policy models.PermissionPolicy, privateId string) bool {
+	return policy != models.PermissionHost || isHost(server, privateId)
+}
+
+// reassignHost hands the host role to the longest-standing player if the
+// current host is no longer in the room.
+func reassignHost(server *models.PokerServer) {
+	newHost := 0
+	for _, p := range server.Players {
+		if p.PublicId == server.HostId {
+			return
+		}
+		if newHost == 0 || p.PublicId < newHost {
+			newHost = p.PublicId
+		}
+	}
+	server.HostId = newHost
 }
 
-func (e *Engine) CleanupOldRooms(maxAge time.Duration) {
+// CleanupOldRooms removes rooms idle for longer than maxAge that have no
+// connected clients and returns their summaries. Expired rooms that still
+// have connections are left in place and returned so the caller can warn
+// those clients before deleting them with DeleteRoom.
+func (e *Engine) CleanupOldRooms(maxAge time.Duration, isConnected func(uuid.UUID) bool) (removed []*models.RoomSummary, connected []uuid.UUID) {
+	e.pruneCreations()
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -296,7 +2430,12 @@ func (e *Engine) CleanupOldRooms(maxAge time.Duration) {
 	playersRemoved := 0
 	for id, s := range e.servers {
 		if now.Sub(s.LastAccess) > maxAge {
+			if isConnected(id) {
+				connected = append(connected, id)
+				continue
+			}
 			playersRemoved += len(s.Players)
+			removed = append(removed, s.Summary())
 			delete(e.servers, id)
 			cleaned++
 		}
@@ -307,4 +2446,78 @@ func (e *Engine) CleanupOldRooms(maxAge time.Duration) {
 		metrics.ActivePlayers.Sub(float64(playersRemoved))
 		slog.Info("Cleaned up old rooms", "roomsRemoved", cleaned, "playersRemoved", playersRemoved, "activeRooms", len(e.servers))
 	}
+
+	return removed, connected
+}
+
+// evictIdleRoom makes space for a new room by removing the least recently
+// used room nobody is in, reporting whether there was one. The caller must
+// hold the write lock.
+func (e *Engine) evictIdleRoom() bool {
+	var oldest *models.PokerServer
+	for id, s := range e.servers {
+		if e.Connected != nil && len(e.Connected(id)) > 0 {
+			continue
+		}
+		if e.Connected == nil && hasAwakePlayers(s) {
+			continue
+		}
+		if oldest == nil || s.LastAccess.Before(oldest.LastAccess) {
+			oldest = s
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+
+	delete(e.servers, oldest.Id)
+	metrics.ActiveRooms.Set(float64(len(e.servers)))
+	metrics.ActivePlayers.Sub(float64(len(oldest.Players)))
+	slog.Info("Evicted idle room to make space", "roomId", oldest.Id, "idle", time.Since(oldest.LastAccess).Round(time.Second), "activeRooms", len(e.servers), "maxRooms", e.MaxRooms)
+	return true
+}
+
+// hasAwakePlayers reports whether anyone in the room is awake, which stands in
+// for being connected when the engine can't ask
+func hasAwakePlayers(server *models.PokerServer) bool {
+	for _, p := range server.Players {
+		if p.Mode == models.Awake {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiringRooms returns the rooms that will pass maxAge within warnBefore,
+// along with how long each has left.
+func (e *Engine) ExpiringRooms(maxAge, warnBefore time.Duration) map[uuid.UUID]time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	expiring := make(map[uuid.UUID]time.Duration)
+	now := time.Now()
+	for id, s := range e.servers {
+		left := maxAge - now.Sub(s.LastAccess)
+		if left > 0 && left <= warnBefore {
+			expiring[id] = left
+		}
+	}
+	return expiring
+}
+
+func (e *Engine) DeleteRoom(id uuid.UUID) (*models.RoomSummary, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.servers[id]
+	if !ok {
+		return nil, false
+	}
+
+	delete(e.servers, id)
+	metrics.ActiveRooms.Set(float64(len(e.servers)))
+	metrics.ActivePlayers.Sub(float64(len(s.Players)))
+	slog.Info("Room deleted", "roomId", id, "playersRemoved", len(s.Players), "activeRooms", len(e.servers))
+
+	return s.Summary(), true
 }
@@ -0,0 +1,756 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"planning-poker-go/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// newRoom creates a room on the given cards, with the default settings
+// changed by configure if it isn't nil
+func newRoom(t *testing.T, e *Engine, cards string, configure func(*models.RoomSettings)) uuid.UUID {
+	t.Helper()
+	settings := models.DefaultRoomSettings()
+	if configure != nil {
+		configure(&settings)
+	}
+	id, err := e.CreateRoom(cards, nil, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// join adds a new player to the room
+func join(t *testing.T, e *Engine, id uuid.UUID, name string, pType models.PlayerType) *models.Player {
+	t.Helper()
+	player, _, err := e.JoinRoom(id, uuid.Nil, name, pType, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return player
+}
+
+// snapshot returns a copy of the room's internals
+func snapshot(t *testing.T, e *Engine, id uuid.UUID) *models.PokerServer {
+	t.Helper()
+	room, ok := e.Snapshot(id)
+	if !ok {
+		t.Fatal("room not found")
+	}
+	return room
+}
+
+// view returns the room as the server broadcasts it
+func view(t *testing.T, e *Engine, id uuid.UUID) *models.ServerView {
+	t.Helper()
+	v, ok := e.View(id)
+	if !ok {
+		t.Fatal("room not found")
+	}
+	return v
+}
+
+func TestEmptyRevealBlocked(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.EmptyReveal = models.EmptyRevealBlock })
+	host := join(t, e, id, "host", models.Participant)
+	if _, err := e.ShowVotes(id, host.Id); !errors.Is(err, ErrNoVotes) {
+		t.Fatalf("want ErrNoVotes, got %v", err)
+	}
+	if view(t, e, id).CurrentSession.IsShown {
+		t.Error("empty round revealed")
+	}
+}
+
+func TestEmptyRevealShown(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	host := join(t, e, id, "host", models.Participant)
+	count, err := e.ShowVotes(id, host.Id)
+	if err != nil || count != 0 {
+		t.Fatalf("want an empty reveal, got %d votes and %v", count, err)
+	}
+	if !view(t, e, id).CurrentSession.IsShown {
+		t.Error("empty round not revealed")
+	}
+}
+
+func TestLowConfidence(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	c := join(t, e, id, "c", models.Participant)
+	e.Vote(id, a.Id, "1", "", models.ConfidenceLow)
+	e.Vote(id, b.Id, "2", "", models.ConfidenceHigh)
+	e.Vote(id, c.Id, "3", "", models.ConfidenceLow)
+	e.Vote(id, c.Id, "3", "", "")
+	if _, err := e.Vote(id, b.Id, "2", "", "unsure"); !errors.Is(err, ErrInvalidConfidence) {
+		t.Errorf("want an unknown confidence refused, got %v", err)
+	}
+	if v := view(t, e, id); len(v.CurrentSession.LowConfidenceIds) != 0 {
+		t.Fatalf("low confidence flagged before the reveal: %v", v.CurrentSession.LowConfidenceIds)
+	}
+
+	e.ShowVotes(id, a.Id)
+	if got := view(t, e, id).CurrentSession.LowConfidenceIds; len(got) != 1 || got[0] != a.PublicId {
+		t.Errorf("want only a flagged, got %v", got)
+	}
+}
+
+func TestAutoRevealRatio(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) {
+		s.AutoReveal = true
+		s.AutoRevealRatio = 0.8
+	})
+	join(t, e, id, "observer", models.Observer)
+	var players []*models.Player
+	for i := 0; i < 5; i++ {
+		players = append(players, join(t, e, id, fmt.Sprint("p", i), models.Participant))
+	}
+
+	for i, p := range players[:4] {
+		result, err := e.Vote(id, p.Id, "2", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := i == 3
+		if result.AutoRevealed != want || view(t, e, id).CurrentSession.IsShown != want {
+			t.Fatalf("after %d of 5 votes: want revealed %v, got %+v", i+1, want, result)
+		}
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	e := NewEngine()
+	off := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.ChatEnabled = false })
+	if _, err := e.AddChat(off, models.ChatMessage{User: "a", Message: "hi"}); !errors.Is(err, ErrChatDisabled) || len(e.ChatHistory(off)) != 0 {
+		t.Error("chat kept with chat disabled")
+	}
+
+	id := newRoom(t, e, "1,2,3", nil)
+	for i := 0; i < maxChatHistory+5; i++ {
+		e.AddChat(id, models.ChatMessage{User: "a", Message: fmt.Sprint(i)})
+	}
+	history := e.ChatHistory(id)
+	if len(history) != maxChatHistory || history[0].Message != "5" {
+		t.Errorf("want the newest %d messages, got %d starting at %q", maxChatHistory, len(history), history[0].Message)
+	}
+}
+
+func TestRecoveryRotation(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	first := join(t, e, id, "a", models.Participant)
+	e.Vote(id, first.Id, "2", "", "")
+
+	second, _, err := e.JoinRoom(id, first.RecoveryId, "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.PublicId != first.PublicId || second.Name != "a" {
+		t.Fatalf("recovered as someone else: %+v", second)
+	}
+	if second.RecoveryId == first.RecoveryId || second.Id == first.Id {
+		t.Error("recovery kept the old IDs")
+	}
+	if _, err := e.Vote(id, first.Id, "3", "", ""); err == nil {
+		t.Error("old private ID still works")
+	}
+	if v := view(t, e, id); len(v.CurrentSession.Voted) != 1 {
+		t.Errorf("vote lost on recovery: %v", v.CurrentSession.Voted)
+	}
+
+	again, _, _ := e.JoinRoom(id, first.RecoveryId, "x", models.Participant, "", "")
+	if again.PublicId == first.PublicId {
+		t.Error("old recovery ID still recovers the session")
+	}
+	if third, _, _ := e.JoinRoom(id, second.RecoveryId, "", "", "", ""); third.PublicId != first.PublicId {
+		t.Error("new recovery ID doesn't recover the session")
+	}
+}
+
+// TestDepartureSettlesRound checks that the round moves on when a player
+// leaves, is kicked or falls asleep. Falling asleep keeps a player's vote.
+func TestDepartureSettlesRound(t *testing.T) {
+	departures := map[string]func(e *Engine, id uuid.UUID, host, p *models.Player) RoundChange{
+		"leave": func(e *Engine, id uuid.UUID, host, p *models.Player) RoundChange {
+			_, change, _ := e.LeaveRoom(id, p.Id)
+			return change
+		},
+		"kick": func(e *Engine, id uuid.UUID, host, p *models.Player) RoundChange {
+			_, change, err := e.KickPlayer(id, host.Id, p.PublicId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return change
+		},
+		"disconnect": func(e *Engine, id uuid.UUID, host, p *models.Player) RoundChange {
+			_, change, _ := e.DisconnectPlayer(id, p.Id)
+			return change
+		},
+	}
+	for name, depart := range departures {
+		t.Run(name, func(t *testing.T) {
+			e := NewEngine()
+			id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.AutoReveal = true })
+			host := join(t, e, id, "host", models.Participant)
+			voter := join(t, e, id, "voter", models.Participant)
+			idle := join(t, e, id, "idle", models.Participant)
+
+			// The only vote going resets the round
+			e.Vote(id, voter.Id, "2", "", "")
+			change, voted := depart(e, id, host, voter), len(view(t, e, id).CurrentSession.Voted)
+			switch {
+			case name == "disconnect" && (change != RoundUnchanged || voted != 1):
+				t.Errorf("want the sleeper's vote kept, got %v with %d votes", change, voted)
+			case name != "disconnect" && (change != RoundReset || voted != 0):
+				t.Errorf("want the round reset, got %v with %d votes", change, voted)
+			}
+
+			// The last player without a vote going reveals it
+			e.Vote(id, host.Id, "3", "", "")
+			if change := depart(e, id, host, idle); change != RoundAutoRevealed {
+				t.Errorf("want the round revealed, got %v", change)
+			}
+			if v := view(t, e, id); !v.CurrentSession.IsShown {
+				t.Error("round not revealed")
+			}
+		})
+	}
+}
+
+func TestAddChat(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.MaxChatLength = 10 })
+	tests := []struct {
+		name    string
+		message string
+		want    string
+		err     error
+	}{
+		{name: "trimmed", message: "  hi there \n", want: "hi there"},
+		{name: "control characters", message: "a\x00b\x1b[31mc\r", want: "ab[31mc"},
+		{name: "newlines kept", message: "one\ntwo", want: "one\ntwo"},
+		{name: "invalid UTF-8", message: "ok\xff\xfe", want: "ok"},
+		{name: "limit counts characters", message: "ééééééééé☕", want: "ééééééééé☕"},
+		{name: "too long", message: "12345678901", err: ErrInvalidChat},
+		{name: "blank", message: " \x00\t ", err: ErrInvalidChat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.AddChat(id, models.ChatMessage{User: "a", Message: tt.message})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got.Message != tt.want {
+				t.Errorf("got %q, want %q", got.Message, tt.want)
+			}
+		})
+	}
+	if history := e.ChatHistory(id); len(history) != 5 {
+		t.Errorf("want the 5 accepted messages kept, got %d", len(history))
+	}
+}
+
+func TestVoteNeedsAPlayer(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	a := join(t, e, id, "a", models.Participant)
+	e.Vote(id, a.Id, "2", "", "")
+
+	for _, privateId := range []string{uuid.NewString(), ""} {
+		if _, err := e.Vote(id, privateId, "3", "", ""); !errors.Is(err, ErrPlayerNotFound) {
+			t.Errorf("vote as %q: want ErrPlayerNotFound, got %v", privateId, err)
+		}
+	}
+	s := snapshot(t, e, id)
+	if len(s.CurrentSession.Votes) != 1 || s.CurrentSession.Votes[fmt.Sprint(a.PublicId)] != "2" {
+		t.Errorf("votes changed: %v", s.CurrentSession.Votes)
+	}
+	if v := view(t, e, id); len(v.CurrentSession.Voted) != 1 || v.CurrentSession.Voted[0] != a.PublicId {
+		t.Errorf("voted changed: %v", v.CurrentSession.Voted)
+	}
+}
+
+func TestSnapshotIsACopy(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	track, err := e.CreateTrack(id, a.Id, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddStories(id, []models.Story{{Title: "one"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.JoinTrack(id, b.Id, track); err != nil {
+		t.Fatal(err)
+	}
+	e.Vote(id, a.Id, "2", "", "")
+	e.Vote(id, b.Id, "3", "", "")
+	room := snapshot(t, e, id)
+	room.Players[a.Id].Name = "changed"
+	delete(room.Players, b.Id)
+	room.Players["new"] = &models.Player{Name: "new"}
+	room.CurrentSession.Votes[fmt.Sprint(a.PublicId)] = "1"
+	room.CurrentSession.Votes["99"] = "1"
+	room.CurrentSession.CardSet[0] = "changed"
+	room.Stories[0].Title = "changed"
+	room.Tracks[track].Votes[fmt.Sprint(b.PublicId)] = "1"
+	delete(room.Tracks, track)
+
+	room = snapshot(t, e, id)
+	if len(room.Players) != 2 || room.Players[a.Id].Name != "a" || room.Players[b.Id] == nil {
+		t.Errorf("players changed: %+v", room.Players)
+	}
+	if want := map[string]string{fmt.Sprint(a.PublicId): "2"}; !maps.Equal(room.CurrentSession.Votes, want) {
+		t.Errorf("votes changed: %v", room.CurrentSession.Votes)
+	}
+	if room.CurrentSession.CardSet[0] != "1" {
+		t.Errorf("cards changed: %v", room.CurrentSession.CardSet)
+	}
+	if room.Stories[0].Title != "one" {
+		t.Errorf("stories changed: %+v", room.Stories)
+	}
+	if ops, ok := room.Tracks[track]; !ok || ops.Votes[fmt.Sprint(b.PublicId)] != "3" {
+		t.Errorf("track changed: %+v", room.Tracks)
+	}
+}
+
+func TestParseCardSet(t *testing.T) {
+	tests := []struct {
+		cards string
+		want  string
+	}{
+		{" 1, 1 ,2,, 3 ,2 ", "1|2|3"},
+		{"S,M,s,M,L", "S|M|s|L"},
+		{"1," + models.Abstention + ",2", "1|2"},
+		{" , ,", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(parseCardSet(tt.cards), "|"); got != tt.want {
+			t.Errorf("parseCardSet(%q) = %s, want %s", tt.cards, got, tt.want)
+		}
+	}
+
+	if _, err := NewEngine().CreateRoom(" , ,", nil, models.DefaultRoomSettings()); !errors.Is(err, ErrEmptyDeck) {
+		t.Errorf("want a deck of blanks refused, got %v", err)
+	}
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,2,3", nil)
+	if got := view(t, e, id).CurrentSession.CardSet; strings.Join(got, "|") != "1|2|3" {
+		t.Errorf("room created with repeated cards: %v", got)
+	}
+}
+
+func TestNilRecoveryId(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	if a.RecoveryId == uuid.Nil || b.RecoveryId == uuid.Nil || a.RecoveryId == b.RecoveryId {
+		t.Errorf("want distinct recovery IDs, got %v and %v", a.RecoveryId, b.RecoveryId)
+	}
+	if a.PublicId == b.PublicId {
+		t.Error("second player recovered the first one's session")
+	}
+	if v := view(t, e, id); len(v.Players) != 2 {
+		t.Errorf("want 2 players, got %d", len(v.Players))
+	}
+}
+
+// TestReusedPublicId checks that a player given the public ID of someone who
+// has gone doesn't inherit a vote still held under it
+func TestReusedPublicId(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	track, err := e.CreateTrack(id, a.Id, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.LeaveRoom(id, b.Id)
+	key := fmt.Sprintf("%d", b.PublicId)
+	e.mu.Lock()
+	e.servers[id].CurrentSession.Votes[key] = "2"
+	e.servers[id].Tracks[track].Votes[key] = "3"
+	e.mu.Unlock()
+
+	c := join(t, e, id, "c", models.Participant)
+	if c.PublicId != b.PublicId {
+		t.Fatalf("want b's public ID reused, got %d", c.PublicId)
+	}
+	room := snapshot(t, e, id)
+	if len(room.CurrentSession.Votes) != 0 || len(room.Tracks[track].Votes) != 0 {
+		t.Errorf("stale votes inherited by c")
+	}
+}
+
+func TestTally(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.MinVotersToReveal = 3 })
+	counts := func() (eligible, voted int) {
+		t.Helper()
+		return tally(snapshot(t, e, id), "")
+	}
+	if eligible, voted := counts(); eligible != 0 || voted != 0 {
+		t.Errorf("empty room: got %d of %d voted", voted, eligible)
+	}
+	join(t, e, id, "o", models.Observer)
+	if eligible, _ := counts(); eligible != 0 {
+		t.Errorf("observer waited for: got %d eligible", eligible)
+	}
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	c := join(t, e, id, "c", models.Participant)
+	e.Vote(id, a.Id, "1", "", "")
+	if eligible, voted := counts(); eligible != 3 || voted != 1 {
+		t.Errorf("want 1 of 3 voted, got %d of %d", voted, eligible)
+	}
+	var tooFew *TooFewVotesError
+	if _, err := e.ShowVotes(id, a.Id); !errors.As(err, &tooFew) || tooFew.Waiting != 2 {
+		t.Errorf("want 2 players waited for, got %v", err)
+	}
+
+	// Neither the observer nor players who have gone hold the round up
+	e.LeaveRoom(id, b.Id)
+	e.DisconnectPlayer(id, c.Id)
+	if eligible, voted := counts(); eligible != 1 || voted != 1 {
+		t.Errorf("want 1 of 1 voted once b and c are gone, got %d of %d", voted, eligible)
+	}
+	if p, ok := e.Progress(id, a.Id); !ok || p.Voted != 1 || p.Total != 1 {
+		t.Errorf("progress disagrees with tally: %+v", p)
+	}
+}
+
+// TestConcurrentPlayers hammers one room from many goroutines and is meant to
+// be run with -race. Votes of players who became observers or were kicked
+// must never be left behind.
+func TestConcurrentPlayers(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3,5,8", nil)
+	host := join(t, e, id, "host", models.Participant)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				p, _, err := e.JoinRoom(id, uuid.Nil, fmt.Sprintf("p%d-%d", i, j), models.Participant, "", "")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				e.Vote(id, p.Id, "3", "", "")
+				e.ChangePlayerType(id, p.Id, models.Observer)
+				e.Vote(id, p.Id, "5", "", "")
+				e.ChangePlayerType(id, p.Id, models.Participant)
+				e.Vote(id, p.Id, "8", "", "")
+				if j%2 == 0 {
+					e.KickPlayer(id, host.Id, p.PublicId)
+				} else {
+					e.LeaveRoom(id, p.Id)
+				}
+				e.View(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	v := view(t, e, id)
+	if len(v.Players) != 1 {
+		t.Fatalf("want only the host left, got %d players", len(v.Players))
+	}
+	if len(v.CurrentSession.Voted) != 0 {
+		t.Errorf("votes left behind by departed players: %v", v.CurrentSession.Voted)
+	}
+}
+
+// TestRevealedRoundKeepsRevealTime checks that recomputing a revealed round's
+// stats, as a departure does, doesn't count as revealing it again
+func TestRevealedRoundKeepsRevealTime(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.AutoClearAfter = 60 })
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	e.Vote(id, a.Id, "1", "", "")
+	e.Vote(id, b.Id, "3", "", "")
+	if _, err := e.ShowVotes(id, a.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, revealedAt, ok := e.AutoClearDue(id)
+	if !ok {
+		t.Fatal("no auto-clear due after the reveal")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	e.LeaveRoom(id, b.Id)
+	if v := view(t, e, id); v.CurrentSession.Stats.Average != 1 {
+		t.Errorf("stats not recomputed without b's vote: %+v", v.CurrentSession.Stats)
+	}
+	if _, again, _ := e.AutoClearDue(id); !again.Equal(revealedAt) {
+		t.Errorf("reveal time moved from %v to %v", revealedAt, again)
+	}
+	if _, cleared := e.AutoClear(id, revealedAt); !cleared {
+		t.Error("auto-clear for the reveal did nothing")
+	}
+}
+
+// TestNilSession checks that a room that lost its main session, as partial
+// state from an import could, can still be played rather than panicking
+func TestNilSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		session *models.PokerSession
+		tracks  map[string]*models.PokerSession
+		card    string
+	}{
+		{name: "no session", card: "13"},
+		{name: "no session, track kept", tracks: map[string]*models.PokerSession{"t": models.NewSession([]string{"S", "M"}, nil, "")}, card: "M"},
+		{name: "no decks", session: &models.PokerSession{CardSet: []string{"1", "2"}}, card: "2"},
+		{name: "no cards", session: &models.PokerSession{}, card: "8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine()
+			id := newRoom(t, e, "1,2", nil)
+			a := join(t, e, id, "a", models.Participant)
+			e.mu.Lock()
+			e.servers[id].CurrentSession = tt.session
+			e.servers[id].Tracks = tt.tracks
+			e.mu.Unlock()
+
+			if v := view(t, e, id); len(v.CurrentSession.Voted) != 0 {
+				t.Fatalf("want an empty round, got %+v", v.CurrentSession)
+			}
+			e.Config(id)
+			e.CountingDown(id)
+			e.Progress(id, a.Id)
+			if _, err := e.Vote(id, a.Id, tt.card, "", ""); err != nil {
+				t.Fatalf("vote %q: %v", tt.card, err)
+			}
+			if _, err := e.ShowVotes(id, a.Id); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.EditDeck(id, a.Id, "1,2,3"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Vote(id, a.Id, "3", "", ""); err != nil {
+				t.Fatalf("vote on the edited deck: %v", err)
+			}
+			e.DisconnectPlayer(id, a.Id)
+		})
+	}
+}
+
+// TestRejoinAsObserver checks that a player who votes then comes back as an
+// observer, by reconnecting or by switching type, leaves no vote behind
+func TestRejoinAsObserver(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.AutoReveal = true })
+	a := join(t, e, id, "a", models.Participant)
+	b := join(t, e, id, "b", models.Participant)
+	e.Vote(id, a.Id, "1", "", "")
+
+	a, change, err := e.JoinRoom(id, a.RecoveryId, "", models.Observer, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change != RoundReset {
+		t.Errorf("want the round reset once its only vote went, got %v", change)
+	}
+	if votes := len(snapshot(t, e, id).CurrentSession.Votes); votes != 0 {
+		t.Fatalf("observer's vote lingers: %d votes", votes)
+	}
+
+	// b is now the only voter, so their vote alone reveals the round
+	e.Vote(id, b.Id, "3", "", "")
+	if v := view(t, e, id); !v.CurrentSession.IsShown || v.CurrentSession.Stats.Average != 3 {
+		t.Fatalf("want b's vote alone revealed, got %+v", v.CurrentSession)
+	}
+
+	e.ClearVotes(id, b.Id)
+	e.ChangePlayerType(id, a.Id, models.Participant)
+	e.Vote(id, a.Id, "2", "", "")
+	e.ChangePlayerType(id, a.Id, models.Observer)
+	if v := view(t, e, id); len(v.CurrentSession.Voted) != 0 {
+		t.Errorf("vote kept after switching to observer: %v", v.CurrentSession.Voted)
+	}
+}
+
+// TestObserverVoteNotCounted checks that a vote left behind by an observer
+// doesn't count towards the votes a reveal needs
+func TestObserverVoteNotCounted(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) {
+		s.AutoReveal = true
+		s.AutoRevealRatio = 0.5
+		s.MinVotersToReveal = 2
+	})
+	a := join(t, e, id, "a", models.Participant)
+	join(t, e, id, "b", models.Participant)
+	c := join(t, e, id, "c", models.Observer)
+	e.mu.Lock()
+	e.servers[id].CurrentSession.Votes[fmt.Sprintf("%d", c.PublicId)] = "3"
+	e.mu.Unlock()
+
+	e.Vote(id, a.Id, "1", "", "")
+	if v := view(t, e, id); v.CurrentSession.IsShown {
+		t.Fatal("auto-revealed on the observer's vote")
+	}
+	_, err := e.ShowVotes(id, a.Id)
+	tooFew, ok := err.(*TooFewVotesError)
+	if !ok {
+		t.Fatalf("want too few votes, got %v", err)
+	}
+	if tooFew.Voted != 1 || tooFew.Waiting != 1 {
+		t.Errorf("want 1 vote and 1 waiting, got %+v", tooFew)
+	}
+}
+
+// TestHideVotesKeepsHostLock checks that undoing a reveal only lifts the lock
+// the countdown set, not one the host set
+func TestHideVotesKeepsHostLock(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) {
+		s.RevealCountdown = 3
+		s.LockOnCountdown = true
+	})
+	a := join(t, e, id, "a", models.Participant)
+	locked := func() bool { return view(t, e, id).CurrentSession.Locked }
+
+	e.Vote(id, a.Id, "1", "", "")
+	e.StartCountdown(id, a.Id)
+	if _, err := e.FinishCountdown(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.HideVotes(id, a.Id); err != nil {
+		t.Fatal(err)
+	}
+	if locked() {
+		t.Error("countdown lock kept after hiding the votes")
+	}
+
+	e.SetLocked(id, a.Id, true)
+	e.StartCountdown(id, a.Id)
+	e.FinishCountdown(id)
+	e.HideVotes(id, a.Id)
+	if !locked() {
+		t.Error("host's lock lifted by hiding the votes")
+	}
+
+	e.ShowVotes(id, a.Id)
+	e.HideVotes(id, a.Id)
+	if !locked() {
+		t.Error("host's lock lifted by hiding a manual reveal")
+	}
+}
+
+func TestKickObservers(t *testing.T) {
+	e := NewEngine()
+	e.ReconnectGrace = 50 * time.Millisecond
+	id := newRoom(t, e, "1,2,3", nil)
+	host := join(t, e, id, "host", models.Observer)
+	o := join(t, e, id, "o", models.Observer)
+	join(t, e, id, "p", models.Participant)
+
+	disconnected := make(chan struct{}, 1)
+	e.DisconnectAfterGrace(id, o.Id, func() { disconnected <- struct{}{} })
+	kicked, _, err := e.KickObservers(id, host.Id)
+	if err != nil || len(kicked) != 1 || kicked[0] != o.Id {
+		t.Fatalf("want only o kicked, got %v and %v", kicked, err)
+	}
+	if v := view(t, e, id); len(v.Players) != 2 || v.HostId != host.PublicId {
+		t.Errorf("want the host and p left with the host kept, got %+v", v)
+	}
+	var kickedErr *KickedError
+	if _, _, err := e.JoinRoom(id, o.RecoveryId, "o", models.Observer, "", ""); !errors.As(err, &kickedErr) {
+		t.Errorf("want o kept out, got %v", err)
+	}
+	select {
+	case <-disconnected:
+		t.Error("pending disconnect fired for a kicked player")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestKickBansAddress(t *testing.T) {
+	e := NewEngine()
+	id := newRoom(t, e, "1,2,3", nil)
+	host := join(t, e, id, "host", models.Participant)
+	guest := join(t, e, id, "guest", models.Participant)
+	colleague := join(t, e, id, "colleague", models.Participant)
+	e.RecordConnection(id, guest.Id, "203.0.113.7:50000", "test")
+	if _, _, err := e.KickPlayer(id, host.Id, guest.PublicId); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.JoinRoom(id, colleague.RecoveryId, "", "", "", "203.0.113.7:50002"); err != nil {
+		t.Errorf("want a player already in the room to resume from the same address, got %v", err)
+	}
+
+	var kickedErr *KickedError
+	for _, recoveryId := range []uuid.UUID{uuid.New(), uuid.Nil} {
+		if _, _, err := e.JoinRoom(id, recoveryId, "guest", models.Participant, "", "203.0.113.7:50001"); !errors.As(err, &kickedErr) {
+			t.Errorf("recovery ID %v: want the kicked address kept out, got %v", recoveryId, err)
+		}
+	}
+	if _, _, err := e.JoinRoom(id, uuid.Nil, "other", models.Participant, "", "198.51.100.1:50000"); err != nil {
+		t.Errorf("want another address let in, got %v", err)
+	}
+}
+
+func TestKickCooldown(t *testing.T) {
+	e := NewEngine()
+	for _, cooldown := range []int{-1, maxKickCooldown + 1} {
+		settings := models.DefaultRoomSettings()
+		settings.KickCooldown = cooldown
+		if _, err := e.CreateRoom("1,2,3", nil, settings); err == nil {
+			t.Errorf("cooldown %d: want an error", cooldown)
+		}
+	}
+
+	id := newRoom(t, e, "1,2,3", func(s *models.RoomSettings) { s.KickCooldown = 0 })
+	host := join(t, e, id, "host", models.Participant)
+	guest := join(t, e, id, "guest", models.Participant)
+	e.RecordConnection(id, guest.Id, "203.0.113.7:50000", "test")
+	if _, _, err := e.KickPlayer(id, host.Id, guest.PublicId); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.JoinRoom(id, guest.RecoveryId, "guest", models.Participant, "", "203.0.113.7:50001"); err != nil {
+		t.Errorf("want no ban with the cooldown off, got %v", err)
+	}
+
+	cooldown := 1
+	if _, err := e.UpdateSettings(id, host.Id, models.SettingsUpdate{KickCooldown: &cooldown}); err != nil {
+		t.Fatal(err)
+	}
+	guest = join(t, e, id, "guest2", models.Participant)
+	if _, _, err := e.KickPlayer(id, host.Id, guest.PublicId); err != nil {
+		t.Fatal(err)
+	}
+	var kickedErr *KickedError
+	if _, _, err := e.JoinRoom(id, guest.RecoveryId, "guest2", models.Participant, "", ""); !errors.As(err, &kickedErr) {
+		t.Fatalf("want a ban once the cooldown is set, got %v", err)
+	}
+	if time.Until(kickedErr.Until) > time.Second {
+		t.Errorf("want the ban to follow the setting, got until %v", kickedErr.Until)
+	}
+	time.Sleep(time.Until(kickedErr.Until) + 10*time.Millisecond)
+	if _, _, err := e.JoinRoom(id, guest.RecoveryId, "guest2", models.Participant, "", ""); err != nil {
+		t.Errorf("want the ban lifted after the cooldown, got %v", err)
+	}
+}
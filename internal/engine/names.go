@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"planning-poker-go/internal/models"
+)
+
+// Word lists for the names given to players who join without one
+var (
+	nameAdjectives = []string{
+		"Brave", "Calm", "Clever", "Curious", "Eager", "Gentle", "Happy", "Jolly",
+		"Keen", "Lively", "Lucky", "Mighty", "Nimble", "Plucky", "Quick", "Quiet",
+		"Sunny", "Swift", "Tidy", "Witty",
+	}
+	nameAnimals = []string{
+		"Badger", "Beaver", "Falcon", "Fox", "Gecko", "Heron", "Koala", "Lemur",
+		"Lynx", "Marmot", "Narwhal", "Otter", "Owl", "Panda", "Puffin", "Quokka",
+		"Raven", "Seal", "Tapir", "Wombat",
+	}
+)
+
+// randomName returns a friendly name such as "Brave Otter"
+func randomName() string {
+	return nameAdjectives[rand.IntN(len(nameAdjectives))] + " " + nameAnimals[rand.IntN(len(nameAnimals))]
+}
+
+// generateName picks a name for a player who joined without one that no one
+// else in the room is using. The caller must hold the engine lock.
+func (e *Engine) generateName(server *models.PokerServer) string {
+	taken := make(map[string]bool, len(server.Players))
+	for _, p := range server.Players {
+		taken[p.Name] = true
+	}
+
+	generate := e.NameGenerator
+	if generate == nil {
+		generate = randomName
+	}
+	name := generate()
+	for i := 0; i < 10 && taken[name]; i++ {
+		name = generate()
+	}
+	// A crowded room can run out of fresh names, so number the last one
+	base := name
+	for n := 2; taken[name]; n++ {
+		name = fmt.Sprintf("%s %d", base, n)
+	}
+	return name
+}
@@ -0,0 +1,48 @@
+package engine
+
+import "time"
+
+// AllowCreate records a room creation attempt from ip and reports whether it
+// is within CreateLimit attempts per CreateWindow. A CreateLimit of 0 turns
+// the limit off.
+func (e *Engine) AllowCreate(ip string) bool {
+	if e.CreateLimit <= 0 {
+		return true
+	}
+
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+
+	now := time.Now()
+	recent := pruneBefore(e.creations[ip], now.Add(-e.CreateWindow))
+	if len(recent) >= e.CreateLimit {
+		e.creations[ip] = recent
+		return false
+	}
+	e.creations[ip] = append(recent, now)
+	return true
+}
+
+// pruneCreations forgets addresses that haven't created a room within the window
+func (e *Engine) pruneCreations() {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+
+	cutoff := time.Now().Add(-e.CreateWindow)
+	for ip, times := range e.creations {
+		if recent := pruneBefore(times, cutoff); len(recent) > 0 {
+			e.creations[ip] = recent
+		} else {
+			delete(e.creations, ip)
+		}
+	}
+}
+
+// pruneBefore drops the leading times that are before cutoff. times must be sorted.
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
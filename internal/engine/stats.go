@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"planning-poker-go/internal/models"
+)
+
+// Special cards carry a meaning rather than an estimate
+const (
+	coffeeCard   = "☕" // The voter would like a break
+	unsureCard   = "?" // The voter needs more information before estimating
+	infinityCard = "∞" // The story is too big to estimate
+)
+
+// specialCards are never treated as numbers, even in decks where they sit
+// alongside numeric cards
+var specialCards = map[string]bool{
+	coffeeCard:        true,
+	unsureCard:        true,
+	infinityCard:      true,
+	models.Abstention: true,
+}
+
+// ComputeStats summarises a revealed round. Votes are parsed as floats, so
+// fractional cards such as "0.5" and large ones such as "1000" are handled
+// alike. Special cards and votes that don't parse as finite numbers are left
+// out of the numeric figures but still count towards the modes and the
+// distribution. Abstentions only count towards the distribution. A spread
+// greater than spreadThreshold flags the round for discussion, and so does
+// any "?" vote: someone asking for more information means the team hasn't
+// converged, however close the numbers are. A break is suggested when every
+// vote other than "?" and abstentions is "☕". Modes and Cards follow the order
+// of cardSet. In a time-based room durations such as "4h" and "2d" are
+// converted to hours.
+func ComputeStats(votes map[string]string, cardSet []string, spreadThreshold float64, unit models.EstimateUnit) models.Stats {
+	stats := models.Stats{
+		Modes:        []string{},
+		Distribution: make(map[string]int),
+	}
+
+	sum := 0.0
+	values := make([]float64, 0, len(votes))
+	for _, vote := range votes {
+		stats.Distribution[vote]++
+		if specialCards[vote] {
+			switch vote {
+			case coffeeCard:
+				stats.CoffeeBreak = true
+			case unsureCard:
+				stats.NeedsInfo = true
+			}
+			continue
+		}
+
+		value, ok := parseEstimate(vote, unit)
+		if !ok {
+			continue
+		}
+		if stats.NumericVotes == 0 || value < stats.Min {
+			stats.Min = value
+			stats.MinVote = vote
+		}
+		if stats.NumericVotes == 0 || value > stats.Max {
+			stats.Max = value
+			stats.MaxVote = vote
+		}
+		sum += value
+		values = append(values, value)
+		stats.NumericVotes++
+	}
+
+	if stats.NumericVotes > 0 {
+		stats.Total = sum
+		stats.Average = sum / float64(stats.NumericVotes)
+		stats.Median = median(values)
+		stats.Spread = stats.Max - stats.Min
+		stats.HighSpread = stats.Spread > spreadThreshold
+	}
+	stats.HighSpread = stats.HighSpread || stats.NeedsInfo
+	cast := len(votes) - stats.Distribution[unsureCard] - stats.Distribution[models.Abstention]
+	stats.BreakSuggested = cast > 0 && stats.Distribution[coffeeCard] == cast
+
+	maxCount := 0
+	for vote, count := range stats.Distribution {
+		if vote == models.Abstention {
+			continue
+		}
+		if count > maxCount {
+			maxCount = count
+			stats.Modes = stats.Modes[:0]
+		}
+		if count == maxCount {
+			stats.Modes = append(stats.Modes, vote)
+		}
+	}
+	orderByDeck(stats.Modes, cardSet)
+
+	stats.Cards = make([]string, 0, len(stats.Distribution))
+	for card := range stats.Distribution {
+		stats.Cards = append(stats.Cards, card)
+	}
+	orderByDeck(stats.Cards, cardSet)
+
+	return stats
+}
+
+// Ideal time units, in hours
+const (
+	hoursPerDay  = 8
+	hoursPerWeek = 5 * hoursPerDay
+)
+
+// timeUnits maps duration suffixes to their length in hours
+var timeUnits = map[string]float64{
+	"m": 1.0 / 60,
+	"h": 1,
+	"d": hoursPerDay,
+	"w": hoursPerWeek,
+}
+
+// parseEstimate returns a vote's numeric value. Time votes are a number with
+// an optional m, h, d or w suffix and are returned in hours; a bare number
+// counts as hours.
+func parseEstimate(vote string, unit models.EstimateUnit) (float64, bool) {
+	scale := 1.0
+	if unit == models.UnitTime {
+		lower := strings.ToLower(vote)
+		for suffix, hours := range timeUnits {
+			if trimmed, found := strings.CutSuffix(lower, suffix); found {
+				vote, scale = strings.TrimSpace(trimmed), hours
+				break
+			}
+		}
+	}
+	value, err := strconv.ParseFloat(vote, 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, false
+	}
+	return value * scale, true
+}
+
+// orderByDeck sorts cards into their order in cardSet. Cards that aren't in
+// the deck, such as free-form votes, go at the end in lexical order.
+func orderByDeck(cards []string, cardSet []string) {
+	index := make(map[string]int, len(cardSet))
+	for i, card := range cardSet {
+		index[card] = i
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		a, aOk := index[cards[i]]
+		b, bOk := index[cards[j]]
+		switch {
+		case aOk && bOk:
+			return a < b
+		case aOk != bOk:
+			return aOk
+		default:
+			return cards[i] < cards[j]
+		}
+	})
+}
+
+// median returns the middle value, or the mean of the two middle values for
+// an even count. values must not be empty and is sorted in place.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"planning-poker-go/internal/models"
+)
+
+func TestBreakSuggested(t *testing.T) {
+	tests := []struct {
+		name  string
+		votes []string
+		want  bool
+	}{
+		{"all coffee", []string{"☕", "☕"}, true},
+		{"coffee and unsure", []string{"☕", "?"}, true},
+		{"coffee and abstention", []string{"☕", models.Abstention}, true},
+		{"coffee and an estimate", []string{"☕", "3"}, false},
+		{"only unsure", []string{"?", models.Abstention}, false},
+		{"no votes", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			votes := make(map[string]string)
+			for i, vote := range tt.votes {
+				votes[string(rune('a'+i))] = vote
+			}
+			if got := ComputeStats(votes, nil, 5, models.UnitPoints).BreakSuggested; got != tt.want {
+				t.Errorf("BreakSuggested = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	s := ComputeStats(map[string]string{"a": "1", "b": "13", "c": "13", "d": "☕", "e": "∞"}, nil, 5, models.UnitPoints)
+	if s.NumericVotes != 3 || s.Min != 1 || s.Max != 13 || s.Spread != 12 || s.Average != 9 {
+		t.Errorf("numeric figures: %+v", s)
+	}
+	if !s.HighSpread || !s.CoffeeBreak || s.NeedsInfo || s.BreakSuggested {
+		t.Errorf("flags: %+v", s)
+	}
+	if len(s.Modes) != 1 || s.Modes[0] != "13" {
+		t.Errorf("modes: %v", s.Modes)
+	}
+
+	s = ComputeStats(map[string]string{"a": "3", "b": "5", "c": "?"}, nil, 5, models.UnitPoints)
+	if s.NumericVotes != 2 || s.Spread != 2 || !s.NeedsInfo || !s.HighSpread {
+		t.Errorf("want an unsure vote to flag a close round: %+v", s)
+	}
+
+	s = ComputeStats(map[string]string{"a": "3", "b": "8"}, nil, 5, models.UnitPoints)
+	if s.HighSpread {
+		t.Errorf("spread at the threshold flagged: %+v", s)
+	}
+
+	s = ComputeStats(map[string]string{}, nil, 5, models.UnitPoints)
+	if s.NumericVotes != 0 || s.Modes == nil || len(s.Modes) != 0 || s.HighSpread {
+		t.Errorf("empty round: %+v", s)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	votes := map[string]string{"a": "13", "b": "2", "c": "xl", "d": "?", "e": "abc", "f": "13", "g": "2", "h": models.Abstention}
+	s := ComputeStats(votes, []string{"1", "2", "3", "13", "?"}, 5, models.UnitPoints)
+	want := map[string]int{"2": 2, "13": 2, "?": 1, "abc": 1, "xl": 1, models.Abstention: 1}
+	if !reflect.DeepEqual(s.Distribution, want) {
+		t.Errorf("distribution: got %v, want %v", s.Distribution, want)
+	}
+	if got := strings.Join(s.Cards, ","); got != "2,13,?,abc,abstain,xl" {
+		t.Errorf("want deck order with other cards last, got %s", got)
+	}
+	if got := strings.Join(s.Modes, ","); got != "2,13" {
+		t.Errorf("modes: %s", got)
+	}
+}
+
+func TestFractionalAndLargeVotes(t *testing.T) {
+	tests := []struct {
+		name             string
+		votes            []string
+		median, avg      float64
+		minVote, maxVote string
+	}{
+		{"fractional and large", []string{"0.5", "100", "1000", "2"}, 51, 1102.5 / 4, "0.5", "1000"},
+		{"special cards left out", []string{"0.5", "3", "?"}, 1.75, 1.75, "0.5", "3"},
+		{"odd count", []string{"8", "1", "3"}, 3, 4, "1", "8"},
+		{"not finite", []string{"Inf", "NaN", "1e400", "5"}, 5, 5, "5", "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			votes := make(map[string]string)
+			for i, vote := range tt.votes {
+				votes[string(rune('a'+i))] = vote
+			}
+			s := ComputeStats(votes, nil, 5, models.UnitPoints)
+			if s.Median != tt.median || s.Average != tt.avg || s.MinVote != tt.minVote || s.MaxVote != tt.maxVote {
+				t.Errorf("got median %v, average %v, min %q and max %q", s.Median, s.Average, s.MinVote, s.MaxVote)
+			}
+		})
+	}
+}
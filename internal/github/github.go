@@ -0,0 +1,146 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRepo  = errors.New("invalid owner or repository")
+	ErrNotFound     = errors.New("repository not found")
+	ErrUnauthorized = errors.New("github rejected the configured token")
+)
+
+// RateLimitError is returned when GitHub refuses a request until Reset
+type RateLimitError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("github rate limit exceeded until %s", e.Reset.Format(time.RFC3339))
+}
+
+// name matches GitHub owner and repository names, which also keeps arbitrary
+// input out of the request path
+var name = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// nextLink pulls the rel="next" URL out of a Link header
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Issue is the part of a GitHub issue used to build a story
+type Issue struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"html_url"`
+}
+
+// Client lists issues through the GitHub REST API. Token is optional; without
+// one only public repositories can be read and GitHub's rate limit is lower.
+type Client struct {
+	BaseURL   string
+	Token     string
+	Client    *http.Client
+	MaxIssues int // Stop paging once this many issues have been collected
+}
+
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL:   "https://api.github.com",
+		Token:     token,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		MaxIssues: 200,
+	}
+}
+
+// OpenIssues returns open issues, not pull requests, in owner/repo that carry
+// all of labels, following pagination up to MaxIssues.
+func (c *Client) OpenIssues(ctx context.Context, owner, repo string, labels []string) ([]Issue, error) {
+	if !name.MatchString(owner) || !name.MatchString(repo) {
+		return nil, ErrInvalidRepo
+	}
+
+	query := url.Values{}
+	query.Set("state", "open")
+	query.Set("per_page", "100")
+	if len(labels) > 0 {
+		query.Set("labels", strings.Join(labels, ","))
+	}
+	next := fmt.Sprintf("%s/repos/%s/%s/issues?%s", strings.TrimRight(c.BaseURL, "/"), owner, repo, query.Encode())
+
+	var issues []Issue
+	for next != "" && len(issues) < c.MaxIssues {
+		page, link, err := c.page(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page {
+			if item.PullRequest != nil {
+				continue // The issues endpoint lists pull requests too
+			}
+			issues = append(issues, item.Issue)
+			if len(issues) == c.MaxIssues {
+				break
+			}
+		}
+		next = link
+	}
+
+	return issues, nil
+}
+
+type issueItem struct {
+	Issue
+	PullRequest *json.RawMessage `json:"pull_request"`
+}
+
+// page fetches one page of issues and returns it with the URL of the next page
+func (c *Client) page(ctx context.Context, pageURL string) ([]issueItem, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.StatusCode == http.StatusTooManyRequests {
+			reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+			return nil, "", &RateLimitError{Reset: time.Unix(reset, 0)}
+		}
+		return nil, "", ErrUnauthorized
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, "", ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, "", ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var items []issueItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, "", fmt.Errorf("decoding issues: %w", err)
+	}
+
+	next := ""
+	if m := nextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	}
+	return items, next, nil
+}
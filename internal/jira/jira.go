@@ -0,0 +1,135 @@
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidKey   = errors.New("invalid issue key")
+	ErrNotFound     = errors.New("issue not found")
+	ErrUnauthorized = errors.New("jira rejected the configured credentials")
+)
+
+// issueKey matches keys such as "PROJ-123", which also keeps arbitrary input
+// out of the request path
+var issueKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+// Issue is the part of a Jira issue used to prefill a story
+type Issue struct {
+	Key         string `json:"key"`
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+}
+
+type cacheEntry struct {
+	issue   Issue
+	expires time.Time
+}
+
+// Client fetches issues from the Jira REST API using an email and API token.
+// Issues are cached for CacheTTL so repeated lookups don't hammer Jira.
+type Client struct {
+	BaseURL  string
+	Email    string
+	Token    string
+	Client   *http.Client
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+func NewClient(baseURL, email, token string) *Client {
+	return &Client{
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		Email:    email,
+		Token:    token,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		CacheTTL: 5 * time.Minute,
+		cache:    make(map[string]cacheEntry),
+	}
+}
+
+// Issue looks up an issue by key, serving it from the cache while it is fresh
+func (c *Client) Issue(ctx context.Context, key string) (Issue, error) {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	if !issueKey.MatchString(key) {
+		return Issue{}, ErrInvalidKey
+	}
+
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.issue, nil
+	}
+
+	issue, err := c.fetch(ctx, key)
+	if err != nil {
+		return Issue{}, err
+	}
+
+	c.mu.Lock()
+	c.cache[key] = cacheEntry{issue: issue, expires: time.Now().Add(c.CacheTTL)}
+	for k, e := range c.cache {
+		if time.Now().After(e.expires) {
+			delete(c.cache, k)
+		}
+	}
+	c.mu.Unlock()
+
+	return issue, nil
+}
+
+func (c *Client) fetch(ctx context.Context, key string) (Issue, error) {
+	endpoint := fmt.Sprintf("%s/rest/api/2/issue/%s?fields=summary,description", c.BaseURL, url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Issue{}, err
+	}
+	req.SetBasicAuth(c.Email, c.Token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return Issue{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Issue{}, ErrNotFound
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Issue{}, ErrUnauthorized
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return Issue{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Summary     string `json:"summary"`
+			Description string `json:"description"`
+		} `json:"fields"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Issue{}, fmt.Errorf("decoding issue: %w", err)
+	}
+
+	return Issue{
+		Key:         body.Key,
+		Summary:     body.Fields.Summary,
+		Description: body.Fields.Description,
+		URL:         c.BaseURL + "/browse/" + url.PathEscape(body.Key),
+	}, nil
+}
@@ -32,6 +32,32 @@ var (
 		Help: "The number of active WebSocket connections",
 	})
 
+	WSBroadcastsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poker_ws_broadcasts_dropped_total",
+		Help: "The total number of broadcasts dropped because the hub was not keeping up",
+	})
+
+	WSUpdatesCoalescedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poker_ws_updates_coalesced_total",
+		Help: "The total number of room updates replaced by a newer one before a slow client received them",
+	})
+
+	WSUpdatesDebouncedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poker_ws_updates_debounced_total",
+		Help: "The total number of room updates merged into one already waiting to be broadcast",
+	})
+
+	WSBroadcastSerializeSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "poker_ws_broadcast_serialize_seconds",
+		Help:    "Time spent encoding each broadcast, by message type",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 8),
+	}, []string{"type"})
+
+	WSSlowClientsDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poker_ws_slow_clients_dropped_total",
+		Help: "The total number of clients disconnected for falling too far behind",
+	})
+
 	WSMessagesReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "poker_ws_messages_received_total",
 		Help: "The total number of WebSocket messages received",
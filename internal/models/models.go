@@ -1,6 +1,11 @@
 package models
 
 import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,21 +32,815 @@ type Player struct {
 	Name      string     `json:"name"`
 	Type      PlayerType `json:"type"`
 	Mode      PlayerMode `json:"mode"`
+	// Team the player estimates for; empty players fall into DefaultGroup
+	Group string `json:"group,omitempty"`
+	// Picked from PlayerPalette at join and kept across reconnects
+	Color string `json:"color"`
+	// Track the player votes in; empty for the room's main session
+	Track string `json:"track,omitempty"`
+	// Where the player last connected from, kept for moderation and only
+	// ever shown to administrators
+	RemoteAddr string `json:"-"`
+	UserAgent  string `json:"-"`
 }
 
+// DefaultGroup is the bucket for players who didn't give a group
+const DefaultGroup = "default"
+
+// PlayerPalette is the set of colors players are shown in, chosen to stay
+// distinguishable from each other and readable on a light background
+var PlayerPalette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b",
+	"#e377c2", "#7f7f7f", "#bcbd22", "#17becf", "#393b79", "#ad494a",
+}
+
+// PlayerColor maps a public ID to its palette color, so players who join one
+// after another get different colors
+func PlayerColor(publicId int) string {
+	n := len(PlayerPalette)
+	return PlayerPalette[((publicId-1)%n+n)%n]
+}
+
+// Abstention is the vote of a player who has no estimate to give. It counts
+// towards reveal like any vote but stays out of the numeric stats.
+const Abstention = "abstain"
+
+// Confidence is how sure a voter is about their estimate
+type Confidence string
+
+const (
+	ConfidenceLow    Confidence = "low"
+	ConfidenceMedium Confidence = "medium"
+	ConfidenceHigh   Confidence = "high"
+)
+
+// EmptyRevealPolicy controls what happens when votes are shown before anyone has voted
+type EmptyRevealPolicy string
+
+const (
+	EmptyRevealShow  EmptyRevealPolicy = "show"  // Reveal the empty round and say so
+	EmptyRevealBlock EmptyRevealPolicy = "block" // Refuse to reveal until someone votes
+)
+
+// PermissionPolicy controls who may perform a round action such as reveal or reset
+type PermissionPolicy string
+
+const (
+	PermissionAnyone PermissionPolicy = "anyone" // Any player in the room
+	PermissionHost   PermissionPolicy = "host"   // Only the room host
+)
+
+// EstimateUnit is what the cards of a room's decks measure
+type EstimateUnit string
+
+const (
+	UnitPoints EstimateUnit = "points" // Plain numbers such as story points
+	UnitTime   EstimateUnit = "time"   // Durations such as "4h" or "2d"
+)
+
+type RoomSettings struct {
+	EmptyReveal EmptyRevealPolicy `json:"emptyReveal"`
+	ChatEnabled bool              `json:"chatEnabled"`
+	AutoReveal  bool              `json:"autoReveal"`
+	// Fraction of awake participants that must have voted before auto-reveal kicks in
+	AutoRevealRatio float64 `json:"autoRevealRatio"`
+	// Spread between the highest and lowest vote above which the round is flagged for discussion
+	SpreadThreshold float64 `json:"spreadThreshold"`
+	// Anonymous rooms only ever reveal how many people picked each card, never who picked what
+	Anonymous bool `json:"anonymous"`
+	// Observers only see the aggregate results, as in an anonymous room, while participants see every vote
+	HideVotesFromObservers bool `json:"hideVotesFromObservers"`
+	// Seconds counted down before a countdown reveal, 0 reveals immediately
+	RevealCountdown int `json:"revealCountdown"`
+	// Lock voting while the reveal countdown runs
+	LockOnCountdown bool `json:"lockOnCountdown"`
+	// Most players the room admits, observers included; 0 means no limit
+	MaxPlayers int `json:"maxPlayers"`
+	// Accept short free-form votes that aren't cards in the active deck
+	AllowCustomVotes bool `json:"allowCustomVotes"`
+	// Who may reveal the votes and who may clear them for a new round
+	RevealPolicy PermissionPolicy `json:"revealPolicy"`
+	ResetPolicy  PermissionPolicy `json:"resetPolicy"`
+	// Shown to each player as they join, e.g. the team's estimation conventions
+	JoinMessage string `json:"joinMessage"`
+	// Seconds a kicked player must wait before rejoining, 0 lets them straight back
+	KickCooldown int `json:"kickCooldown"`
+	// What the cards measure. Time cards are added up in hours, which is also
+	// the unit of SpreadThreshold in a time-based room.
+	EstimateUnit EstimateUnit `json:"estimateUnit"`
+	// Longest player name and chat message, in characters
+	MaxNameLength int `json:"maxNameLength"`
+	MaxChatLength int `json:"maxChatLength"`
+	// Fewest votes a reveal needs. At 1 the empty reveal policy alone decides.
+	MinVotersToReveal int `json:"minVotersToReveal"`
+	// Deck a second value is picked from with each vote, e.g. risk alongside
+	// effort. Empty for single-scale voting.
+	SecondScale string `json:"secondScale"`
+	// Votes are cast sealed, as commitments, and each voter opens their own
+	// on reveal, so no one can learn or change a vote before then
+	CommitReveal bool `json:"commitReveal"`
+	// New players who don't say how they are taking part join as observers
+	JoinAsObserver bool `json:"joinAsObserver"`
+	// Observers other than the host may only chat, and can't reveal, clear,
+	// change decks or otherwise steer the round
+	ObserversChatOnly bool `json:"observersChatOnly"`
+	// Don't echo voters' own votes back to them while the others' are hidden,
+	// e.g. when a voter's screen is shared with the room
+	HideOwnVote bool `json:"hideOwnVote"`
+	// Seconds a revealed round stays up before it is cleared and the next
+	// story, if any, comes up. 0 leaves clearing to the players.
+	AutoClearAfter int `json:"autoClearAfter"`
+}
+
+// SettingsUpdate is a partial RoomSettings; nil fields are left unchanged
+type SettingsUpdate struct {
+	EmptyReveal            *EmptyRevealPolicy `json:"emptyReveal"`
+	ChatEnabled            *bool              `json:"chatEnabled"`
+	AutoReveal             *bool              `json:"autoReveal"`
+	AutoRevealRatio        *float64           `json:"autoRevealRatio"`
+	SpreadThreshold        *float64           `json:"spreadThreshold"`
+	Anonymous              *bool              `json:"anonymous"`
+	HideVotesFromObservers *bool              `json:"hideVotesFromObservers"`
+	RevealCountdown        *int               `json:"revealCountdown"`
+	LockOnCountdown        *bool              `json:"lockOnCountdown"`
+	MaxPlayers             *int               `json:"maxPlayers"`
+	AllowCustomVotes       *bool              `json:"allowCustomVotes"`
+	RevealPolicy           *PermissionPolicy  `json:"revealPolicy"`
+	ResetPolicy            *PermissionPolicy  `json:"resetPolicy"`
+	JoinMessage            *string            `json:"joinMessage"`
+	KickCooldown           *int               `json:"kickCooldown"`
+	EstimateUnit           *EstimateUnit      `json:"estimateUnit"`
+	MaxNameLength          *int               `json:"maxNameLength"`
+	MaxChatLength          *int               `json:"maxChatLength"`
+	MinVotersToReveal      *int               `json:"minVotersToReveal"`
+	SecondScale            *string            `json:"secondScale"`
+	CommitReveal           *bool              `json:"commitReveal"`
+	JoinAsObserver         *bool              `json:"joinAsObserver"`
+	ObserversChatOnly      *bool              `json:"observersChatOnly"`
+	HideOwnVote            *bool              `json:"hideOwnVote"`
+	AutoClearAfter         *int               `json:"autoClearAfter"`
+}
+
+// Apply returns a copy of settings with the update's fields applied
+func (u SettingsUpdate) Apply(settings RoomSettings) RoomSettings {
+	if u.EmptyReveal != nil {
+		settings.EmptyReveal = *u.EmptyReveal
+	}
+	if u.ChatEnabled != nil {
+		settings.ChatEnabled = *u.ChatEnabled
+	}
+	if u.AutoReveal != nil {
+		settings.AutoReveal = *u.AutoReveal
+	}
+	if u.AutoRevealRatio != nil {
+		settings.AutoRevealRatio = *u.AutoRevealRatio
+	}
+	if u.SpreadThreshold != nil {
+		settings.SpreadThreshold = *u.SpreadThreshold
+	}
+	if u.Anonymous != nil {
+		settings.Anonymous = *u.Anonymous
+	}
+	if u.HideVotesFromObservers != nil {
+		settings.HideVotesFromObservers = *u.HideVotesFromObservers
+	}
+	if u.RevealCountdown != nil {
+		settings.RevealCountdown = *u.RevealCountdown
+	}
+	if u.LockOnCountdown != nil {
+		settings.LockOnCountdown = *u.LockOnCountdown
+	}
+	if u.MaxPlayers != nil {
+		settings.MaxPlayers = *u.MaxPlayers
+	}
+	if u.AllowCustomVotes != nil {
+		settings.AllowCustomVotes = *u.AllowCustomVotes
+	}
+	if u.RevealPolicy != nil {
+		settings.RevealPolicy = *u.RevealPolicy
+	}
+	if u.ResetPolicy != nil {
+		settings.ResetPolicy = *u.ResetPolicy
+	}
+	if u.JoinMessage != nil {
+		settings.JoinMessage = *u.JoinMessage
+	}
+	if u.KickCooldown != nil {
+		settings.KickCooldown = *u.KickCooldown
+	}
+	if u.EstimateUnit != nil {
+		settings.EstimateUnit = *u.EstimateUnit
+	}
+	if u.MaxNameLength != nil {
+		settings.MaxNameLength = *u.MaxNameLength
+	}
+	if u.MaxChatLength != nil {
+		settings.MaxChatLength = *u.MaxChatLength
+	}
+	if u.MinVotersToReveal != nil {
+		settings.MinVotersToReveal = *u.MinVotersToReveal
+	}
+	if u.SecondScale != nil {
+		settings.SecondScale = *u.SecondScale
+	}
+	if u.CommitReveal != nil {
+		settings.CommitReveal = *u.CommitReveal
+	}
+	if u.JoinAsObserver != nil {
+		settings.JoinAsObserver = *u.JoinAsObserver
+	}
+	if u.ObserversChatOnly != nil {
+		settings.ObserversChatOnly = *u.ObserversChatOnly
+	}
+	if u.HideOwnVote != nil {
+		settings.HideOwnVote = *u.HideOwnVote
+	}
+	if u.AutoClearAfter != nil {
+		settings.AutoClearAfter = *u.AutoClearAfter
+	}
+	return settings
+}
+
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		EmptyReveal:       EmptyRevealShow,
+		ChatEnabled:       true,
+		AutoRevealRatio:   1,
+		SpreadThreshold:   5,
+		RevealPolicy:      PermissionAnyone,
+		ResetPolicy:       PermissionAnyone,
+		KickCooldown:      60,
+		EstimateUnit:      UnitPoints,
+		MaxNameLength:     32,
+		MaxChatLength:     1000,
+		MinVotersToReveal: 1,
+	}
+}
+
+// Stats summarise the votes of a revealed round
+type Stats struct {
+	Average      float64  `json:"average"`
+	Median       float64  `json:"median"`
+	Min          float64  `json:"min"`
+	Max          float64  `json:"max"`
+	Spread       float64  `json:"spread"`       // Max - Min
+	HighSpread   bool     `json:"highSpread"`   // Spread exceeds the room's threshold
+	NumericVotes int      `json:"numericVotes"` // Votes included in the numeric figures
+	Modes        []string `json:"modes"`        // Most common votes, numeric or not
+	CoffeeBreak  bool     `json:"coffeeBreak"`  // Someone played the coffee card
+	// Everyone played the coffee card
+	BreakSuggested bool `json:"breakSuggested"`
+	// Someone played "?" and needs more information, which also sets HighSpread
+	NeedsInfo bool `json:"needsInfo"`
+	// Number of votes per card, numeric or not
+	Distribution map[string]int `json:"distribution"`
+	// Keys of Distribution in deck order, with cards not in the deck last
+	Cards []string `json:"cards"`
+	// Min and Max as the cards were written, e.g. "0.5" rather than 0.5
+	MinVote string `json:"minVote"`
+	MaxVote string `json:"maxVote"`
+	// Sum of the numeric votes, in hours for time-based rooms
+	Total float64 `json:"total"`
+}
+
+// Copy returns a deep copy of the stats
+func (s *Stats) Copy() *Stats {
+	stats := *s
+	stats.Modes = append([]string(nil), s.Modes...)
+	stats.Cards = append([]string(nil), s.Cards...)
+	stats.Distribution = make(map[string]int, len(s.Distribution))
+	for card, count := range s.Distribution {
+		stats.Distribution[card] = count
+	}
+	return &stats
+}
+
+// Copy returns a deep copy of the session
+func (session *PokerSession) Copy() *PokerSession {
+	s := *session
+	s.CardSet = slices.Clone(session.CardSet)
+	if session.Decks != nil {
+		s.Decks = make(map[string][]string, len(session.Decks))
+		for name, cards := range session.Decks {
+			s.Decks[name] = slices.Clone(cards)
+		}
+	}
+	s.Votes = maps.Clone(session.Votes)
+	s.Confidence = maps.Clone(session.Confidence)
+	s.VotedAt = maps.Clone(session.VotedAt)
+	s.SecondVotes = maps.Clone(session.SecondVotes)
+	s.Sealed = maps.Clone(session.Sealed)
+	if session.Stats != nil {
+		s.Stats = session.Stats.Copy()
+	}
+	if session.SecondStats != nil {
+		s.SecondStats = session.SecondStats.Copy()
+	}
+	if session.GroupStats != nil {
+		s.GroupStats = make(map[string]*Stats, len(session.GroupStats))
+		for group, stats := range session.GroupStats {
+			s.GroupStats[group] = stats.Copy()
+		}
+	}
+	return &s
+}
+
+// DefaultDeck is the name given to the card set a room is created with
+const DefaultDeck = "default"
+
 type PokerSession struct {
-	CardSet []string          `json:"cardSet"`
+	CardSet    []string            `json:"cardSet"` // Cards of the active deck
+	Decks      map[string][]string `json:"decks"`
+	ActiveDeck string              `json:"activeDeck"`
 	Votes   map[string]string `json:"votes"` // Key is PublicId as string
 	IsShown bool              `json:"isShown"`
+	Locked  bool              `json:"locked"` // Votes can't be cast or withdrawn while locked
+	CountingDown bool         `json:"countingDown"` // A reveal countdown is running
+	// Whether the lock was set by the reveal countdown rather than the host
+	CountdownLock bool `json:"-"`
+	// Optional confidence attached to a vote, keyed like Votes
+	Confidence map[string]Confidence `json:"-"`
+	// When each vote was last cast, keyed like Votes
+	VotedAt map[string]time.Time `json:"-"`
+	Stats   *Stats               `json:"stats,omitempty"` // Computed on reveal
+	// Stats per player group, computed on reveal when anyone in the room has a group
+	GroupStats map[string]*Stats `json:"groupStats,omitempty"`
+	// Values on the room's second scale, keyed like Votes, and their stats on reveal
+	SecondVotes map[string]string `json:"-"`
+	SecondStats *Stats            `json:"secondStats,omitempty"`
+	// Votes still held as commitments, keyed like Votes, and whether their
+	// voters have been asked to open them
+	Sealed  map[string]bool `json:"-"`
+	Opening bool            `json:"opening"`
+	// When the round was started and when it was last revealed
+	StartedAt  time.Time `json:"-"`
+	RevealedAt time.Time `json:"-"`
+}
+
+// NewSession starts a session on a copy of cardSet with its first round open
+func NewSession(cardSet []string, decks map[string][]string, activeDeck string) *PokerSession {
+	return &PokerSession{
+		CardSet:     append([]string(nil), cardSet...),
+		Decks:       decks,
+		ActiveDeck:  activeDeck,
+		Votes:       make(map[string]string),
+		Confidence:  make(map[string]Confidence),
+		VotedAt:     make(map[string]time.Time),
+		SecondVotes: make(map[string]string),
+		Sealed:      make(map[string]bool),
+		StartedAt:   time.Now(),
+	}
+}
+
+// RoundDuration is how long the round took from its start until it was
+// revealed, zero while it is still running
+func (session *PokerSession) RoundDuration() time.Duration {
+	if !session.IsShown || session.StartedAt.IsZero() || session.RevealedAt.Before(session.StartedAt) {
+		return 0
+	}
+	return session.RevealedAt.Sub(session.StartedAt)
+}
+
+// VoteTiming is when a player cast their vote in a revealed round
+type VoteTiming struct {
+	PublicId int       `json:"publicId"`
+	VotedAt  time.Time `json:"votedAt"`
+}
+
+// Story is an item in the room's estimation queue
+type Story struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	IssueKey    string `json:"issueKey,omitempty"` // Optional Jira issue the story was taken from
+	URL         string `json:"url,omitempty"`      // Optional link to the story in its tracker
+	// The value the team agreed on, set by the host after discussion
+	FinalEstimate string `json:"finalEstimate,omitempty"`
+	// The team moved on without an estimate, e.g. because the story needs refinement
+	Skipped bool `json:"skipped,omitempty"`
 }
 
 type PokerServer struct {
 	Id             uuid.UUID          `json:"id"`
 	Players        map[string]*Player `json:"players"` // Key is Private ID
 	CurrentSession *PokerSession      `json:"currentSession"`
+	Settings       RoomSettings       `json:"settings"`
+	HostId         int                `json:"hostId"` // PublicId of the host, 0 until someone joins
+	ChatHistory    []ChatMessage      `json:"-"`      // Recent chat, replayed to players as they join
+	ActivityLog    []LogMessage       `json:"-"`      // Recent log entries, served to late joiners
+	Stories        []Story            `json:"stories"`
+	StoryIndex     int                `json:"storyIndex"` // Position of the active story in Stories
 	LastAccess     time.Time          `json:"-"`
+	// Recovery IDs of kicked players and when they may rejoin
+	Bans map[uuid.UUID]time.Time `json:"-"`
+	// Addresses kicked players connected from, by the same rule
+	AddrBans map[string]time.Time `json:"-"`
+	// Parallel sessions by name, for meetings that split up to estimate
+	// separate epics at once. CurrentSession is the main track.
+	Tracks map[string]*PokerSession `json:"tracks,omitempty"`
+	// Note the host keeps in view for everyone, e.g. "Remember: include testing effort"
+	PinnedNote string `json:"pinnedNote,omitempty"`
+}
+
+// Copy returns a deep copy of the room, sharing nothing with the original
+func (s *PokerServer) Copy() *PokerServer {
+	room := *s
+	room.Players = make(map[string]*Player, len(s.Players))
+	for privateId, p := range s.Players {
+		player := *p
+		room.Players[privateId] = &player
+	}
+	if s.CurrentSession != nil {
+		room.CurrentSession = s.CurrentSession.Copy()
+	}
+	room.ChatHistory = slices.Clone(s.ChatHistory)
+	room.ActivityLog = slices.Clone(s.ActivityLog)
+	for i, entry := range room.ActivityLog {
+		room.ActivityLog[i].Params = slices.Clone(entry.Params)
+	}
+	room.Stories = slices.Clone(s.Stories)
+	room.Bans = maps.Clone(s.Bans)
+	room.AddrBans = maps.Clone(s.AddrBans)
+	if s.Tracks != nil {
+		room.Tracks = make(map[string]*PokerSession, len(s.Tracks))
+		for name, track := range s.Tracks {
+			room.Tracks[name] = track.Copy()
+		}
+	}
+	return &room
+}
+
+// Session returns the session of the named track, or nil if there is no such
+// track. The empty name is the main track.
+func (s *PokerServer) Session(track string) *PokerSession {
+	if track == "" {
+		// A room that lost its main session reads as an empty one until the
+		// engine repairs it on its next write
+		if s.CurrentSession == nil {
+			return NewSession(nil, nil, "")
+		}
+		return s.CurrentSession
+	}
+	return s.Tracks[track]
+}
+
+// RoomConfig is how a room was set up once defaults and decks are resolved
+type RoomConfig struct {
+	Id         uuid.UUID           `json:"id"`
+	CardSet    []string            `json:"cardSet"`
+	Decks      map[string][]string `json:"decks"`
+	ActiveDeck string              `json:"activeDeck"`
+	Settings   RoomSettings        `json:"settings"`
+}
+
+// Config must be called with the engine lock held
+func (s *PokerServer) Config() *RoomConfig {
+	session := s.Session("")
+	config := &RoomConfig{
+		Id:         s.Id,
+		CardSet:    append([]string(nil), session.CardSet...),
+		Decks:      make(map[string][]string, len(session.Decks)),
+		ActiveDeck: session.ActiveDeck,
+		Settings:   s.Settings,
+	}
+	for name, cards := range session.Decks {
+		config.Decks[name] = append([]string(nil), cards...)
+	}
+	return config
+}
+
+// RoomSummary is the final state of a room reported when it goes away
+type RoomSummary struct {
+	RoomId     uuid.UUID         `json:"roomId"`
+	Players    int               `json:"players"`
+	ActiveDeck string            `json:"activeDeck"`
+	Votes      map[string]string `json:"votes,omitempty"` // Only when the last round was revealed
+	// Seconds the last round took, only when it was revealed
+	RoundDuration int       `json:"roundDuration,omitempty"`
+	LastAccess    time.Time `json:"lastAccess"`
+}
+
+// Summary must be called with the engine lock held
+func (s *PokerServer) Summary() *RoomSummary {
+	session := s.Session("")
+	summary := &RoomSummary{
+		RoomId:     s.Id,
+		Players:    len(s.Players),
+		ActiveDeck: session.ActiveDeck,
+		LastAccess: s.LastAccess,
+	}
+	summary.RoundDuration = int(session.RoundDuration().Round(time.Second).Seconds())
+	if session.IsShown && !s.Settings.Anonymous && len(session.Votes) > 0 {
+		summary.Votes = make(map[string]string, len(session.Votes))
+		for key, vote := range session.Votes {
+			summary.Votes[key] = vote
+		}
+	}
+	return summary
+}
+
+// PlayerView is the public representation of a Player sent to other clients.
+// It deliberately leaves out the private and recovery IDs.
+type PlayerView struct {
+	PublicId int        `json:"publicId"`
+	Name     string     `json:"name"`
+	Type     PlayerType `json:"type"`
+	Mode     PlayerMode `json:"mode"`
+	Group    string     `json:"group,omitempty"`
+	Color    string     `json:"color"`
+	Track    string     `json:"track,omitempty"`
+	// Awake with a live connection, as opposed to merely still in the room
+	Online bool `json:"online"`
+}
+
+type SessionView struct {
+	CardSet    []string            `json:"cardSet"`
+	Decks      map[string][]string `json:"decks"`
+	ActiveDeck string              `json:"activeDeck"`
+	Votes   map[string]string `json:"votes"` // Empty until IsShown
+	Voted   []int             `json:"voted"` // Public IDs that have cast a vote
+	IsShown bool              `json:"isShown"`
+	Locked  bool              `json:"locked"`
+	CountingDown bool         `json:"countingDown"`
+	// Public IDs of voters with low confidence, only sent on reveal so the team can discuss them first
+	LowConfidenceIds []int  `json:"lowConfidenceIds,omitempty"`
+	Stats            *Stats `json:"stats,omitempty"`
+	// Number of votes per card, sent on reveal in place of Votes when individual votes are withheld
+	Distribution map[string]int `json:"distribution,omitempty"`
+	// Voters from fastest to slowest, sent on reveal
+	VoteOrder []VoteTiming `json:"voteOrder,omitempty"`
+	// Stats per player group, sent on reveal unless individual votes are withheld
+	GroupStats map[string]*Stats `json:"groupStats,omitempty"`
+	// Values and stats on the room's second scale, sent on reveal. The values
+	// are withheld along with Votes.
+	SecondVotes map[string]string `json:"secondVotes,omitempty"`
+	SecondStats *Stats            `json:"secondStats,omitempty"`
+	// Voters should open their sealed votes, see RoomSettings.CommitReveal
+	Opening bool `json:"opening"`
+	// When the round started, and in seconds how long it took, sent on reveal
+	StartedAt time.Time `json:"startedAt"`
+	Duration  int       `json:"duration,omitempty"`
+}
+
+// View returns the player as other players see them
+func (p *Player) View() PlayerView {
+	return PlayerView{
+		PublicId: p.PublicId,
+		Name:     p.Name,
+		Type:     p.Type,
+		Mode:     p.Mode,
+		Group:    p.Group,
+		Color:    p.Color,
+		Track:    p.Track,
+	}
 }
 
+// AdminPlayerView is a player as shown to administrators, with the details
+// needed to look into abuse
+type AdminPlayerView struct {
+	PlayerView
+	Host       bool   `json:"host"`
+	RemoteAddr string `json:"remoteAddr"`
+	UserAgent  string `json:"userAgent"`
+}
+
+// ConnectionInfo describes one open WebSocket connection to a room
+type ConnectionInfo struct {
+	RemoteAddr string `json:"remoteAddr"`
+	UserAgent  string `json:"userAgent"`
+	Joined     bool   `json:"joined"`   // The connection has joined as a player
+	ReadOnly   bool   `json:"readOnly"` // Opened with ?present
+}
+
+// AdminRoomView is the admin room-detail response. Unlike the views sent to
+// players it includes where everyone connects from.
+type AdminRoomView struct {
+	Room        *ServerView       `json:"room"`
+	Players     []AdminPlayerView `json:"players"`
+	Connections []ConnectionInfo  `json:"connections"`
+}
+
+// ServerView is the room state as broadcast to clients
+type ServerView struct {
+	Id             uuid.UUID             `json:"id"`
+	Players        map[string]PlayerView `json:"players"` // Key is PublicId as string
+	CurrentSession SessionView           `json:"currentSession"`
+	Settings       RoomSettings          `json:"settings"`
+	HostId         int                   `json:"hostId"`
+	Stories        []Story               `json:"stories"`
+	StoryIndex     int                   `json:"storyIndex"` // Only meaningful when Stories is non-empty
+	// The room's other tracks by name; players say which track they are in
+	Tracks     map[string]SessionView `json:"tracks,omitempty"`
+	PinnedNote string                 `json:"pinnedNote,omitempty"`
+}
+
+// View copies the room into its client-facing form, redacting vote values
+// until they are shown. The caller must hold the engine lock.
+func (s *PokerServer) View() *ServerView {
+	return s.view(s.Settings.Anonymous, "")
+}
+
+// ViewFor is View as seen by one player, who also sees their own vote unless
+// the room hides it. When the room hides votes from observers, observers and
+// anyone not in the room only get the aggregate results. The caller must hold
+// the engine lock.
+func (s *PokerServer) ViewFor(privateId string) *ServerView {
+	return s.view(s.Settings.Anonymous || s.HidesVotesFrom(privateId), s.ownVoteKey(privateId))
+}
+
+// SeesOnlyOwnVote reports whether the player has a vote that they would see
+// in ViewFor but that View and the redacted view withhold, so the player needs
+// a view of their own
+func (s *PokerServer) SeesOnlyOwnVote(privateId string) bool {
+	key := s.ownVoteKey(privateId)
+	if key == "" {
+		return false
+	}
+	session := s.Session(s.Players[privateId].Track)
+	if _, voted := session.Votes[key]; !voted || session.Sealed[key] {
+		return false
+	}
+	return !session.IsShown || s.Settings.Anonymous || s.HidesVotesFrom(privateId)
+}
+
+// ownVoteKey is the key under which the player's own vote is shown to them,
+// or empty if it isn't
+func (s *PokerServer) ownVoteKey(privateId string) string {
+	player, ok := s.Players[privateId]
+	if !ok || s.Settings.HideOwnVote || s.Session(player.Track) == nil {
+		return ""
+	}
+	return strconv.Itoa(player.PublicId)
+}
+
+// HidesVotesFrom reports whether individual votes are withheld from the player
+// under the room's HideVotesFromObservers setting
+func (s *PokerServer) HidesVotesFrom(privateId string) bool {
+	if !s.Settings.HideVotesFromObservers {
+		return false
+	}
+	player, ok := s.Players[privateId]
+	return !ok || player.Type == Observer
+}
+
+// view builds the client-facing room. aggregateOnly withholds everything that
+// ties a vote to a voter, leaving the distribution and stats, except for the
+// vote under ownKey, which is always kept.
+func (s *PokerServer) view(aggregateOnly bool, ownKey string) *ServerView {
+	view := &ServerView{
+		Id:             s.Id,
+		Players:        make(map[string]PlayerView, len(s.Players)),
+		CurrentSession: s.Session("").view(aggregateOnly, ownKey),
+		Settings:       s.Settings,
+		HostId:         s.HostId,
+		Stories:        append([]Story{}, s.Stories...),
+		StoryIndex:     s.StoryIndex,
+		PinnedNote:     s.PinnedNote,
+	}
+
+	for _, p := range s.Players {
+		view.Players[strconv.Itoa(p.PublicId)] = p.View()
+	}
+
+	if len(s.Tracks) > 0 {
+		view.Tracks = make(map[string]SessionView, len(s.Tracks))
+		for name, track := range s.Tracks {
+			view.Tracks[name] = track.view(aggregateOnly, ownKey)
+		}
+	}
+
+	return view
+}
+
+// view builds the client-facing form of one session, as for PokerServer.view.
+// A sealed vote is only ever a commitment, so it isn't kept under ownKey.
+func (session *PokerSession) view(aggregateOnly bool, ownKey string) SessionView {
+	view := SessionView{
+		CardSet:      append([]string(nil), session.CardSet...),
+		Decks:        make(map[string][]string, len(session.Decks)),
+		ActiveDeck:   session.ActiveDeck,
+		Votes:        make(map[string]string),
+		Voted:        make([]int, 0, len(session.Votes)),
+		IsShown:      session.IsShown,
+		Locked:       session.Locked,
+		CountingDown: session.CountingDown,
+		Opening:      session.Opening,
+		StartedAt:    session.StartedAt,
+	}
+
+	for name, cards := range session.Decks {
+		view.Decks[name] = append([]string(nil), cards...)
+	}
+
+	for key, vote := range session.Votes {
+		if publicId, err := strconv.Atoi(key); err == nil {
+			view.Voted = append(view.Voted, publicId)
+		}
+		if (session.IsShown && !aggregateOnly) || (key == ownKey && !session.Sealed[key]) {
+			view.Votes[key] = vote
+		}
+	}
+	sort.Ints(view.Voted)
+
+	if !session.IsShown {
+		return view
+	}
+
+	view.Duration = int(session.RoundDuration().Round(time.Second).Seconds())
+
+	if session.Stats != nil {
+		stats := session.Stats.Copy()
+		view.Stats = stats
+		if aggregateOnly {
+			view.Distribution = stats.Distribution
+		}
+	}
+	if session.SecondStats != nil {
+		view.SecondStats = session.SecondStats.Copy()
+		if !aggregateOnly {
+			view.SecondVotes = make(map[string]string, len(session.SecondVotes))
+			for key, value := range session.SecondVotes {
+				view.SecondVotes[key] = value
+			}
+		}
+	}
+	// A small group's distribution would give its members' votes away
+	if !aggregateOnly && session.GroupStats != nil {
+		view.GroupStats = make(map[string]*Stats, len(session.GroupStats))
+		for group, stats := range session.GroupStats {
+			view.GroupStats[group] = stats.Copy()
+		}
+	}
+	for key, confidence := range session.Confidence {
+		if aggregateOnly {
+			break // Flagging voters would attribute their votes
+		}
+		if confidence != ConfidenceLow {
+			continue
+		}
+		if _, voted := session.Votes[key]; !voted {
+			continue
+		}
+		if publicId, err := strconv.Atoi(key); err == nil {
+			view.LowConfidenceIds = append(view.LowConfidenceIds, publicId)
+		}
+	}
+	sort.Ints(view.LowConfidenceIds)
+
+	for key, votedAt := range session.VotedAt {
+		if publicId, err := strconv.Atoi(key); err == nil {
+			view.VoteOrder = append(view.VoteOrder, VoteTiming{PublicId: publicId, VotedAt: votedAt})
+		}
+	}
+	sort.Slice(view.VoteOrder, func(i, j int) bool {
+		return view.VoteOrder[i].VotedAt.Before(view.VoteOrder[j].VotedAt)
+	})
+
+	return view
+}
+
+// ProtocolVersion is the version of the WebSocket protocol this server speaks.
+// Bump it whenever an action or message changes shape incompatibly.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest client protocol the server still accepts
+const MinProtocolVersion = 1
+
+// Action is a request sent by a client over the WebSocket
+type Action string
+
+const (
+	ActionJoin          Action = "join"
+	ActionVote          Action = "vote"
+	ActionUnvote        Action = "unvote"
+	ActionShow          Action = "show"
+	ActionHideVotes     Action = "hideVotes"
+	ActionClear         Action = "clear"
+	ActionChangeDeck    Action = "changeDeck"
+	ActionEditDeck      Action = "editDeck"
+	ActionLockVoting    Action = "lockVoting"
+	ActionNextStory     Action = "nextStory"
+	ActionPrevStory     Action = "prevStory"
+	ActionSetEstimate   Action = "setEstimate"
+	ActionKick          Action = "kick"
+	ActionKickObservers Action = "kickObservers"
+	ActionForceObserver Action = "forceObserver"
+	ActionChangeType    Action = "changeType"
+	ActionChat          Action = "chat"
+	ActionClearChat     Action = "clearChat"
+	ActionTyping        Action = "typing"
+	ActionResync        Action = "resync"
+	ActionLeave         Action = "leave"
+	ActionKeepAlive     Action = "keepAlive"
+	ActionUpdateSettings Action = "updateSettings"
+	ActionCreateTrack   Action = "createTrack"
+	ActionJoinTrack     Action = "joinTrack"
+	ActionResetRoom     Action = "resetRoom"
+	ActionOpenVote      Action = "openVote"
+	ActionPinNote       Action = "pinNote"
+	ActionAbstain       Action = "abstain"
+	ActionSkipStory     Action = "skipStory"
+)
+
 // Hub Messages
 type MessageType string
 
@@ -52,6 +851,22 @@ const (
 	MessageTypeClear   MessageType = "clear"
 	MessageTypeJoinSuccess MessageType = "join_success"
 	MessageTypeChat    MessageType = "chat"
+	MessageTypeTyping  MessageType = "typing"
+	MessageTypeError   MessageType = "error"
+	MessageTypeRoomClosed MessageType = "room_closed"
+	MessageTypeTypeChanged MessageType = "type_changed"
+	MessageTypeChatHistory MessageType = "chat_history"
+	MessageTypeChatCleared MessageType = "chat_cleared"
+	MessageTypeCountdown   MessageType = "countdown"
+	MessageTypeWelcome     MessageType = "welcome"
+	MessageTypeIdleWarning MessageType = "idle_warning"
+	MessageTypeSystem      MessageType = "system"
+	MessageTypeAck         MessageType = "ack"
+	MessageTypeRoomReset   MessageType = "room_reset"
+	MessageTypeProgress    MessageType = "progress"
+	// Deltas, sent in place of "updated" to clients that opted in
+	MessageTypeVoteCast      MessageType = "vote_cast"
+	MessageTypePlayerUpdated MessageType = "player_updated"
 )
 
 type HubMessage struct {
@@ -60,8 +875,13 @@ type HubMessage struct {
 }
 
 type LogMessage struct {
-	User      string    `json:"user"`
-	Message   string    `json:"message"`
+	User    string `json:"user"`
+	Message string `json:"message"` // In English
+	// What happened, with the values its message was filled in with, so
+	// clients can word the entry themselves
+	EventType string    `json:"eventType"`
+	Params    []string  `json:"params,omitempty"`
+	Track     string    `json:"track,omitempty"` // Set for events in a track other than the main one
 	Timestamp time.Time `json:"timestamp"`
 }
 
@@ -70,3 +890,78 @@ type ChatMessage struct {
 	Message   string    `json:"message"`
 	Timestamp time.Time `json:"timestamp"`
 }
+
+// WelcomeMessage is sent as soon as a WebSocket connects so the client can
+// check it speaks a compatible protocol before joining
+type WelcomeMessage struct {
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	Palette            []string `json:"palette"` // Colors players can be given, see PlayerPalette
+}
+
+// SystemMessage is a note from the room rather than from a player, such as
+// the room's join message
+type SystemMessage struct {
+	Message string `json:"message"`
+}
+
+// IdleWarningMessage tells a quiet room it is about to be closed for inactivity
+type IdleWarningMessage struct {
+	ExpiresIn int `json:"expiresIn"` // Seconds until the room is closed
+}
+
+// ProgressMessage is how many of a track's awake participants have voted,
+// sent to everyone as votes come in so clients needn't count them up from a
+// full update
+type ProgressMessage struct {
+	Track string `json:"track,omitempty"` // Empty for the main track
+	Voted int    `json:"voted"`
+	Total int    `json:"total"`
+}
+
+// VoteCastMessage says a player cast or withdrew a vote. Either way the
+// player is now awake.
+type VoteCastMessage struct {
+	PublicId int  `json:"publicId"`
+	Voted    bool `json:"voted"`
+}
+
+// CountdownMessage is broadcast once a second while a reveal countdown runs
+type CountdownMessage struct {
+	Remaining int `json:"remaining"`
+}
+
+// ErrorMessage is sent to a single client to explain why its action failed
+type ErrorMessage struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Id      json.RawMessage `json:"id,omitempty"` // The failed action's id, if it sent one
+	// Participants yet to vote, set when a reveal is refused for too few votes
+	Waiting *int `json:"waiting,omitempty"`
+}
+
+// AckMessage confirms that an action sent with an id succeeded
+type AckMessage struct {
+	Id json.RawMessage `json:"id"`
+}
+
+// KickedMessage tells a player they were removed from the room. RetryIn is
+// set when they tried to rejoin too soon.
+type KickedMessage struct {
+	RetryIn int `json:"retryIn,omitempty"` // Seconds until they may rejoin
+}
+
+// ClearMessage says which track a "clear" started a new round in. Clears
+// sent without one are for the main track.
+type ClearMessage struct {
+	Track string `json:"track"`
+}
+
+type RoomClosedMessage struct {
+	Reason string `json:"reason"`
+}
+
+type TypingMessage struct {
+	User     string `json:"user"`
+	IsTyping bool   `json:"isTyping"`
+}
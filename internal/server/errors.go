@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"planning-poker-go/internal/engine"
+	"planning-poker-go/internal/models"
+)
+
+// Error codes sent to clients in MessageTypeError so the UI can react to a
+// failed action without parsing the human-readable message
+const (
+	ErrCodeRoomNotFound        = "room_not_found"
+	ErrCodePlayerNotFound      = "player_not_found"
+	ErrCodeObserverVote        = "observer_vote"
+	ErrCodeRevealed            = "already_revealed"
+	ErrCodeNotRevealed         = "not_revealed"
+	ErrCodeNoVotes             = "no_votes"
+	ErrCodeTooFewVotes         = "too_few_votes"
+	ErrCodeNotHost             = "not_host"
+	ErrCodeInvalidVote         = "invalid_vote"
+	ErrCodeLocked              = "voting_locked"
+	ErrCodeCountdown           = "countdown_running"
+	ErrCodeNoStory             = "no_story"
+	ErrCodeInvalidEstimate     = "invalid_estimate"
+	ErrCodeRoomFull            = "room_full"
+	ErrCodeChatDisabled        = "chat_disabled"
+	ErrCodeInvalidMessage      = "invalid_message"
+	ErrCodeInvalidRequest      = "invalid_request"
+	ErrCodeInvalidPayload      = "invalid_payload"
+	ErrCodeInvalidName         = "invalid_name"
+	ErrCodeUnknownTrack        = "unknown_track"
+	ErrCodeInvalidTrack        = "invalid_track"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeChatOnly            = "chat_only"
+	ErrCodeUnsupportedProtocol = "unsupported_protocol"
+)
+
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{engine.ErrRoomNotFound, ErrCodeRoomNotFound},
+	{engine.ErrPlayerNotFound, ErrCodePlayerNotFound},
+	{engine.ErrObserverVote, ErrCodeObserverVote},
+	{engine.ErrRevealed, ErrCodeRevealed},
+	{engine.ErrNotRevealed, ErrCodeNotRevealed},
+	{engine.ErrNoVotes, ErrCodeNoVotes},
+	{engine.ErrTooFewVotes, ErrCodeTooFewVotes},
+	{engine.ErrNotHost, ErrCodeNotHost},
+	{engine.ErrInvalidVote, ErrCodeInvalidVote},
+	{engine.ErrInvalidConfidence, ErrCodeInvalidVote},
+	{engine.ErrInvalidSecondVote, ErrCodeInvalidVote},
+	{engine.ErrInvalidCommitment, ErrCodeInvalidVote},
+	{engine.ErrBadOpening, ErrCodeInvalidVote},
+	{engine.ErrNotOpening, ErrCodeInvalidRequest},
+	{engine.ErrInvalidPlayerType, ErrCodeInvalidRequest},
+	{engine.ErrRoomFull, ErrCodeRoomFull},
+	{engine.ErrLocked, ErrCodeLocked},
+	{engine.ErrCountdownRunning, ErrCodeCountdown},
+	{engine.ErrNoStory, ErrCodeNoStory},
+	{engine.ErrNoActiveStory, ErrCodeNoStory},
+	{engine.ErrInvalidEstimate, ErrCodeInvalidEstimate},
+	{engine.ErrNameTooLong, ErrCodeInvalidName},
+	{engine.ErrInvalidChat, ErrCodeInvalidMessage},
+	{engine.ErrNoteTooLong, ErrCodeInvalidMessage},
+	{engine.ErrChatDisabled, ErrCodeChatDisabled},
+	{engine.ErrUnknownTrack, ErrCodeUnknownTrack},
+	{engine.ErrTrackExists, ErrCodeInvalidTrack},
+	{engine.ErrInvalidTrackName, ErrCodeInvalidTrack},
+}
+
+// errorCode maps an engine error to its client-facing code
+func errorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ErrCodeInvalidRequest
+}
+
+// sendError tells the client why its action failed
+func (s *Server) sendError(c *Client, err error) {
+	msg := models.ErrorMessage{Code: errorCode(err), Message: err.Error()}
+	var tooFew *engine.TooFewVotesError
+	if errors.As(err, &tooFew) {
+		msg.Waiting = &tooFew.Waiting
+	}
+	s.sendErrorMessage(c, msg)
+}
+
+func (s *Server) sendErrorCode(c *Client, code, message string) {
+	s.sendErrorMessage(c, models.ErrorMessage{Code: code, Message: message})
+}
+
+func (s *Server) sendErrorMessage(c *Client, msg models.ErrorMessage) {
+	c.requestFailed = true
+	msg.Id = c.requestId
+	s.sendTo(c, models.HubMessage{
+		Type:    models.MessageTypeError,
+		Payload: msg,
+	})
+}
+
+// ignoreAction drops an action the client can't perform. Only clients waiting
+// on an acknowledgement are told, so those that send no ids see no change.
+func (s *Server) ignoreAction(c *Client, message string) {
+	if c.requestId != nil {
+		s.sendErrorCode(c, ErrCodeInvalidRequest, message)
+	}
+}
+
+// decodePayload unmarshals an action's payload into v. When it can't, the
+// client is told which action and field were malformed and false is
+// returned. A missing payload leaves v at its zero value.
+func (s *Server) decodePayload(c *Client, action models.Action, payload json.RawMessage, v any) bool {
+	if len(payload) == 0 || string(payload) == "null" {
+		return true
+	}
+	err := json.Unmarshal(payload, v)
+	if err == nil {
+		return true
+	}
+	slog.Warn("Malformed action payload", "action", action, "error", err, "roomId", c.RoomId)
+	s.sendErrorCode(c, ErrCodeInvalidPayload, payloadError(action, err))
+	return false
+}
+
+// payloadError describes a payload decoding error for client developers
+func payloadError(action models.Action, err error) string {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("Invalid %s payload: field %q must be %s, not %s", action, typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("Invalid %s payload: expected %s, not %s", action, typeErr.Type, typeErr.Value)
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Invalid %s payload: not valid JSON at offset %d", action, syntaxErr.Offset)
+	default:
+		return fmt.Sprintf("Invalid %s payload: %v", action, err)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"planning-poker-go/internal/models"
+)
+
+// Activity log event types sent in LogMessage.EventType, so clients can show
+// the entry in their own language from the event and its params rather than
+// the English message
+const (
+	LogStoriesAdded     = "stories_added"
+	LogJoined           = "joined"
+	LogRenamed          = "renamed"
+	LogVoted            = "voted"
+	LogVoteChanged      = "vote_changed"
+	LogAbstained        = "abstained"
+	LogVoteWithdrawn    = "vote_withdrawn"
+	LogAutoRevealed     = "auto_revealed"
+	LogCountdownStarted = "countdown_started"
+	LogCountdownTooFew  = "countdown_too_few"
+	LogCountdownNoVotes = "countdown_no_votes"
+	LogCountdownOpening = "countdown_opening"
+	LogOpening          = "opening"
+	LogVotesOpened      = "votes_opened"
+	LogRevealed         = "revealed"
+	LogRevealedEmpty    = "revealed_empty"
+	LogVotesHidden      = "votes_hidden"
+	LogVotesCleared     = "votes_cleared"
+	LogRoundReset       = "round_reset"
+	LogAutoCleared      = "auto_cleared"
+	LogBreakSuggested   = "break_suggested"
+	LogTrackOpened      = "track_opened"
+	LogMovedToMain      = "moved_to_main"
+	LogMovedToTrack     = "moved_to_track"
+	LogDeckChanged      = "deck_changed"
+	LogCardsChanged     = "cards_changed"
+	LogVotingLocked     = "voting_locked"
+	LogVotingUnlocked   = "voting_unlocked"
+	LogStoryChanged     = "story_changed"
+	LogEstimated        = "estimated"
+	LogEstimateCleared  = "estimate_cleared"
+	LogStorySkipped     = "story_skipped"
+	LogQueueFinished    = "queue_finished"
+	LogSettingsUpdated  = "settings_updated"
+	LogObserversRemoved = "observers_removed"
+	LogMadeObserver     = "made_observer"
+	LogTypeChanged      = "type_changed"
+	LogNotePinned       = "note_pinned"
+	LogNoteUnpinned     = "note_unpinned"
+	LogChatCleared      = "chat_cleared"
+	LogRoomReset        = "room_reset"
+	LogKeptOpen         = "kept_open"
+	LogLeft             = "left"
+)
+
+// logMessages is the English message of each event, given its params in order
+var logMessages = map[string]string{
+	LogStoriesAdded:     "Added %s stories to the queue",
+	LogJoined:           "Joined the room",
+	LogRenamed:          "Changed their name from %s",
+	LogVoted:            "Voted",
+	LogVoteChanged:      "Changed vote",
+	LogAbstained:        "Abstained",
+	LogVoteWithdrawn:    "Redacted their vote",
+	LogAutoRevealed:     "Enough votes are in, revealing automatically",
+	LogCountdownStarted: "Started the reveal countdown",
+	LogCountdownTooFew:  "Countdown ended, but too few votes are in to reveal",
+	LogCountdownNoVotes: "Countdown ended, but no one voted",
+	LogCountdownOpening: "Countdown ended, open your sealed votes",
+	LogOpening:          "Asked everyone to open their sealed votes",
+	LogVotesOpened:      "Every sealed vote matched its commitment",
+	LogRevealed:         "Made all votes visible",
+	LogRevealedEmpty:    "Made all votes visible, but no one voted",
+	LogVotesHidden:      "Hid the votes again after they were revealed, voting is open",
+	LogVotesCleared:     "Cleared all votes",
+	LogRoundReset:       "The last vote was withdrawn, starting a new round",
+	LogAutoCleared:      "Cleared the votes automatically, starting a new round",
+	LogBreakSuggested:   "Everyone picked ☕, break time!",
+	LogTrackOpened:      "Opened the %s track",
+	LogMovedToMain:      "Moved to the main track",
+	LogMovedToTrack:     "Moved to the %s track",
+	LogDeckChanged:      "Switched to the %s deck",
+	LogCardsChanged:     "Changed the cards to %s",
+	LogVotingLocked:     "Locked voting",
+	LogVotingUnlocked:   "Unlocked voting",
+	LogStoryChanged:     "Moved on to %s",
+	LogEstimated:        "Estimated %s at %s",
+	LogEstimateCleared:  "Cleared the estimate for %s",
+	LogStorySkipped:     "Skipped %s without an estimate",
+	LogQueueFinished:    "That was the last story in the queue",
+	LogSettingsUpdated:  "Updated the room settings",
+	LogObserversRemoved: "Removed %s observers",
+	LogMadeObserver:     "Made %s an observer",
+	LogTypeChanged:      "Changed their player type to %s",
+	LogNotePinned:       "Pinned a note: %s",
+	LogNoteUnpinned:     "Unpinned the note",
+	LogChatCleared:      "Cleared the chat",
+	LogRoomReset:        "Reset the room for a new session",
+	LogKeptOpen:         "Kept the room open",
+	LogLeft:             "Left the room",
+}
+
+// logEntry builds an activity log entry for an event. The English message
+// names the track, if there is one, at the end.
+func logEntry(user, track, event string, params ...string) models.LogMessage {
+	args := make([]any, len(params))
+	for i, param := range params {
+		args[i] = param
+	}
+	return models.LogMessage{
+		User:      user,
+		Message:   fmt.Sprintf(logMessages[event], args...) + inTrack(track),
+		EventType: event,
+		Params:    params,
+		Track:     track,
+		Timestamp: time.Now(),
+	}
+}
+
+// inTrack names a track in a log message. The main track goes unnamed, as it
+// is the only one in most rooms.
+func inTrack(track string) string {
+	if track == "" {
+		return ""
+	}
+	return " in the " + track + " track"
+}
@@ -1,20 +1,72 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"planning-poker-go/internal/engine"
+	"planning-poker-go/internal/github"
+	"planning-poker-go/internal/jira"
 	"planning-poker-go/internal/metrics"
 	"planning-poker-go/internal/models"
+	"planning-poker-go/internal/webhook"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// maxMessageSize caps a single incoming WebSocket message so one client can't
+// make ReadMessage allocate unbounded buffers
+const maxMessageSize = 64 * 1024
+
+// writeWait bounds each WebSocket write so a wedged client can't stall its
+// writePump and back up the hub behind a full send channel
+const writeWait = 10 * time.Second
+
+// pongWait is how long a connection may stay silent, pongs included, before
+// it is considered dead. Pings go out often enough that a live client always
+// answers in time.
+const (
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// broadcastBuffer lets bursts of events queue up while the hub is busy
+const broadcastBuffer = 256
+
+// sendBuffer is how many messages may queue up for one client. Once half of
+// it is taken the client is falling behind, and instead of queueing every
+// room update it is only sent the newest, keeping room for the messages that
+// can't be coalesced such as chat.
+const (
+	sendBuffer        = 256
+	coalesceThreshold = sendBuffer / 2
+)
+
+// publishTimeout is how long Publish waits on a full broadcast queue
+const publishTimeout = time.Second
+
+// resyncInterval is how often a client may ask for the full room state, each
+// of which costs the server a complete view
+const resyncInterval = time.Second
+
+// compressionThreshold is the smallest message, in bytes, worth compressing
+// when Server.Compression is on. Below it, such as for chat, typing and vote
+// deltas, deflate costs more than it saves.
+const compressionThreshold = 1024
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -28,26 +80,155 @@ type Client struct {
 	Conn     *websocket.Conn
 	Send     chan []byte
 	RoomId   uuid.UUID
+	// PlayerId is empty until the client joins. Only the read pump changes it,
+	// through setPlayerId, so it reads it freely; anyone else must hold Hub.Mu.
 	PlayerId string
+	// Where the connection came from, for moderation. Never broadcast.
+	RemoteAddr string
+	UserAgent  string
+	// ReadOnly clients, such as a room shown on a shared screen, receive
+	// updates but never join and have their actions ignored. Set before the
+	// client is registered and never changed.
+	ReadOnly bool
+	// Deltas is set when the client asked for delta updates on join
+	Deltas atomic.Bool
+	// Deltas sent since the last full update, only touched by the hub
+	deltasSinceSync int
+	// The id of the action being handled, if it sent one, and whether it
+	// failed. Only touched by the read pump.
+	requestId     json.RawMessage
+	requestFailed bool
+	// When the client last asked for a resync, only touched by the read pump
+	lastResync time.Time
+	// The newest room update for a client that fell behind, written once
+	// everything queued before it has gone out
+	pendingMu    sync.Mutex
+	pending      []byte
+	pendingReady chan struct{}
+	dropOnce     sync.Once
+}
+
+// setPlayerId records the player the client acts as, under the hub's lock so
+// the hub and its other readers never see it change mid-read
+func (c *Client) setPlayerId(playerId string) {
+	c.Hub.Mu.Lock()
+	c.PlayerId = playerId
+	c.Hub.Mu.Unlock()
+}
+
+// queueUpdate queues a full room update. A client that has fallen behind
+// gets it held back instead, replacing any update still held back, so it
+// only ever catches up on the newest state.
+func (c *Client) queueUpdate(msg []byte) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if c.pending == nil && len(c.Send) < coalesceThreshold {
+		select {
+		case c.Send <- msg:
+			return
+		default:
+		}
+	}
+	if c.pending != nil {
+		metrics.WSUpdatesCoalescedTotal.Inc()
+	}
+	c.pending = msg
+	select {
+	case c.pendingReady <- struct{}{}:
+	default:
+	}
+}
+
+// queueDelta queues a delta update, reporting false if the client is behind
+// and should get the full update instead. A delta must not overtake a full
+// update that is being held back, since that update would then undo it.
+func (c *Client) queueDelta(msg []byte) bool {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if c.pending != nil || len(c.Send) >= coalesceThreshold {
+		return false
+	}
+	select {
+	case c.Send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver queues a message that can't be coalesced. A client too far behind
+// to take it is disconnected, so it reconnects and starts afresh.
+func (c *Client) deliver(msg []byte) {
+	select {
+	case c.Send <- msg:
+	default:
+		c.dropSlow()
+	}
+}
+
+// dropSlow closes the connection of a client that fell too far behind,
+// telling it why so it can reconnect. Its read pump then unregisters it.
+func (c *Client) dropSlow() {
+	c.dropOnce.Do(func() {
+		metrics.WSSlowClientsDroppedTotal.Inc()
+		slog.Warn("Disconnecting client that fell behind", "roomId", c.RoomId)
+		// Closing can wait on the network, so keep it off the hub's goroutine
+		go func() {
+			c.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "You're falling behind, resyncing"),
+				time.Now().Add(writeWait))
+			c.Conn.Close()
+		}()
+	})
+}
+
+// takePending returns the held back update, if any, once nothing queued
+// before it is left to send
+func (c *Client) takePending() []byte {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if len(c.Send) > 0 {
+		return nil
+	}
+	msg := c.pending
+	c.pending = nil
+	return msg
 }
 
+// fullSyncEvery is how many deltas a client gets before the next full update,
+// so a client that misapplied one can't drift for long
+const fullSyncEvery = 20
+
 type Hub struct {
 	Rooms      map[uuid.UUID]map[*Client]bool
 	Broadcast  chan HubEvent
 	Register   chan *Client
 	Unregister chan *Client
 	Mu         sync.RWMutex
+	// OnRoomEmpty, if set, is called in its own goroutine when the last client
+	// other than a read-only one leaves a room
+	OnRoomEmpty func(roomId uuid.UUID)
 }
 
 type HubEvent struct {
 	RoomId  uuid.UUID
 	Message models.HubMessage
+	Exclude *Client // Optional client that should not receive the message
+	// Optional compact form of Message for clients that opted into deltas
+	Delta *models.HubMessage
+	// Optional form of Message with individual votes withheld, sent to every
+	// client whose player is not in FullViewers
+	Redacted    *models.HubMessage
+	FullViewers map[string]bool
+	// Optional forms of Message for single players, sent to the player they
+	// are keyed by in place of Message or Redacted
+	Personal map[string]*models.HubMessage
 }
 
 func NewHub() *Hub {
 	return &Hub{
 		Rooms:      make(map[uuid.UUID]map[*Client]bool),
-		Broadcast:  make(chan HubEvent),
+		Broadcast:  make(chan HubEvent, broadcastBuffer),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 	}
@@ -66,54 +247,562 @@ func (h *Hub) Run() {
 			metrics.WSConnectionsActive.Inc()
 		case client := <-h.Unregister:
 			h.Mu.Lock()
+			emptied := false
 			if _, ok := h.Rooms[client.RoomId]; ok {
 				delete(h.Rooms[client.RoomId], client)
 				close(client.Send)
+				emptied = !client.ReadOnly && !h.hasClients(client.RoomId)
 				if len(h.Rooms[client.RoomId]) == 0 {
 					delete(h.Rooms, client.RoomId)
 				}
 			}
 			h.Mu.Unlock()
 			metrics.WSConnectionsActive.Dec()
+			if emptied && h.OnRoomEmpty != nil {
+				go h.OnRoomEmpty(client.RoomId)
+			}
 		case event := <-h.Broadcast:
 			h.Mu.RLock()
+			start := time.Now()
 			msg, _ := json.Marshal(event.Message)
+			var delta, redacted []byte
+			if event.Delta != nil {
+				delta, _ = json.Marshal(event.Delta)
+			}
+			if event.Redacted != nil {
+				redacted, _ = json.Marshal(event.Redacted)
+			}
+			personal := make(map[string][]byte, len(event.Personal))
+			for playerId, message := range event.Personal {
+				personal[playerId], _ = json.Marshal(message)
+			}
+			metrics.WSBroadcastSerializeSeconds.WithLabelValues(string(event.Message.Type)).Observe(time.Since(start).Seconds())
 			for client := range h.Rooms[event.RoomId] {
-				select {
-				case client.Send <- msg:
-				default:
-					close(client.Send)
-					delete(h.Rooms[event.RoomId], client)
+				if client == event.Exclude {
+					continue
+				}
+				out := msg
+				if redacted != nil && !event.FullViewers[client.PlayerId] {
+					out = redacted
+				}
+				if own, ok := personal[client.PlayerId]; ok {
+					out = own
+				}
+				if event.Message.Type != models.MessageTypeUpdated {
+					client.deliver(out)
+					continue
 				}
+				if delta != nil && client.Deltas.Load() && client.deltasSinceSync < fullSyncEvery && client.queueDelta(delta) {
+					client.deltasSinceSync++
+					continue
+				}
+				client.deltasSinceSync = 0
+				client.queueUpdate(out)
 			}
 			h.Mu.RUnlock()
 		}
 	}
 }
 
+// Publish queues an event for broadcast. If the hub can't keep up it gives up
+// after publishTimeout and drops the event, so a wedged hub can't stall every
+// action goroutine. Clients that miss an update catch up on the next one or
+// by sending resync.
+func (h *Hub) Publish(event HubEvent) bool {
+	select {
+	case h.Broadcast <- event:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(publishTimeout)
+	defer timer.Stop()
+	select {
+	case h.Broadcast <- event:
+		return true
+	case <-timer.C:
+		metrics.WSBroadcastsDroppedTotal.Inc()
+		slog.Warn("Dropped broadcast, hub is not keeping up", "roomId", event.RoomId, "type", event.Message.Type)
+		return false
+	}
+}
+
+// ConnectedRooms returns the rooms that have at least one client connected,
+// read-only ones included
+func (h *Hub) ConnectedRooms() []uuid.UUID {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	rooms := make([]uuid.UUID, 0, len(h.Rooms))
+	for roomId := range h.Rooms {
+		rooms = append(rooms, roomId)
+	}
+	return rooms
+}
+
+// ConnectedPlayers returns the private IDs of the players with an open
+// connection to the room
+func (h *Hub) ConnectedPlayers(roomId uuid.UUID) map[string]bool {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	connected := make(map[string]bool, len(h.Rooms[roomId]))
+	for client := range h.Rooms[roomId] {
+		if client.PlayerId != "" {
+			connected[client.PlayerId] = true
+		}
+	}
+	return connected
+}
+
+// Connections describes the room's open connections for administrators
+func (h *Hub) Connections(roomId uuid.UUID) []models.ConnectionInfo {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	connections := make([]models.ConnectionInfo, 0, len(h.Rooms[roomId]))
+	for client := range h.Rooms[roomId] {
+		connections = append(connections, models.ConnectionInfo{
+			RemoteAddr: client.RemoteAddr,
+			UserAgent:  client.UserAgent,
+			Joined:     client.PlayerId != "",
+			ReadOnly:   client.ReadOnly,
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].RemoteAddr < connections[j].RemoteAddr })
+	return connections
+}
+
+// HasClients reports whether any client other than a read-only one is
+// connected to the room, so a screen left showing a room can't keep it alive
+func (h *Hub) HasClients(roomId uuid.UUID) bool {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	return h.hasClients(roomId)
+}
+
+// hasClients is HasClients for callers that hold the lock
+func (h *Hub) hasClients(roomId uuid.UUID) bool {
+	for client := range h.Rooms[roomId] {
+		if !client.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseRoom drops every connection to the room. Each client's read pump then
+// unregisters it as usual.
+func (h *Hub) CloseRoom(roomId uuid.UUID) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	for client := range h.Rooms[roomId] {
+		client.Conn.Close()
+	}
+}
+
+// roomClosingGrace is how long clients of an expired room have to react to
+// the room-closed message before they are disconnected.
+const roomClosingGrace = 5 * time.Second
+
+// systemUser is the name shown on log entries the server generates itself
+const systemUser = "System"
+
 type Server struct {
-	Engine *engine.Engine
-	Hub    *Hub
+	Engine     *engine.Engine
+	Hub        *Hub
+	Notifier   *webhook.Notifier // Optional, receives room expiry events
+	AdminToken string            // Bearer token for admin endpoints, which are refused when empty
+	Jira       *jira.Client      // Optional, enables the Jira lookup endpoint
+	GitHub     *github.Client    // Imports GitHub issues into the story queue
+
+	// UpdateDebounce holds room updates back this long so that a burst of
+	// changes, such as an action and the log entry it causes, goes out as one
+	// update. 0 sends every update straight away.
+	UpdateDebounce time.Duration
+	updateMu       sync.Mutex
+	pendingUpdates map[uuid.UUID]*models.HubMessage // Delta of each held update, nil for a full one
+
+	// Compression offers permessage-deflate to clients, shrinking large room
+	// updates at the cost of CPU on every message compressed
+	Compression bool
+}
+
+// RefreshRooms sends every room with clients connected its full state, so a
+// client that missed an update catches up without having to ask for a resync.
+// It is a safety net behind the event-driven updates and is meant to run on
+// a slow timer. Rooms nobody is connected to are skipped.
+func (s *Server) RefreshRooms() {
+	for _, roomId := range s.Hub.ConnectedRooms() {
+		s.publishUpdate(roomId, nil)
+	}
+}
+
+// CleanupOldRooms removes idle rooms. Rooms that still have clients connected
+// are told they are closing and deleted after a short grace period, so an
+// open tab sees a clear message rather than silently failing actions.
+// Connected rooms due to expire within warnBefore are warned so they can send
+// any action to stay open.
+func (s *Server) CleanupOldRooms(maxAge, warnBefore time.Duration) {
+	for roomId, left := range s.Engine.ExpiringRooms(maxAge, warnBefore) {
+		if !s.Hub.HasClients(roomId) {
+			continue
+		}
+		s.Hub.Publish(HubEvent{
+			RoomId: roomId,
+			Message: models.HubMessage{
+				Type:    models.MessageTypeIdleWarning,
+				Payload: models.IdleWarningMessage{ExpiresIn: int(left.Seconds())},
+			},
+		})
+	}
+
+	removed, connected := s.Engine.CleanupOldRooms(maxAge, s.Hub.HasClients)
+	for _, summary := range removed {
+		s.Notifier.Notify(webhook.EventRoomExpired, summary)
+	}
+	for _, roomId := range connected {
+		s.closeRoom(roomId, "This room was closed due to inactivity")
+	}
+}
+
+func (s *Server) closeRoom(roomId uuid.UUID, reason string) {
+	slog.Info("Closing room", "roomId", roomId, "reason", reason)
+	s.Hub.Publish(HubEvent{
+		RoomId: roomId,
+		Message: models.HubMessage{
+			Type:    models.MessageTypeRoomClosed,
+			Payload: models.RoomClosedMessage{Reason: reason},
+		},
+	})
+	time.AfterFunc(roomClosingGrace, func() {
+		if summary, ok := s.Engine.DeleteRoom(roomId); ok {
+			s.Notifier.Notify(webhook.EventRoomExpired, summary)
+		}
+		s.Hub.CloseRoom(roomId)
+	})
+}
+
+// writeJSON sends v as the JSON body of a response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write JSON response", "error", err)
+	}
+}
+
+// writeError sends an HTTP error as {"error": message}, so API clients can
+// decode failures the same way as successes
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{message})
 }
 
 func (s *Server) HandleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !s.Engine.AllowCreate(ip) {
+		slog.Warn("Room creation rate limited", "remoteAddr", ip)
+		writeError(w, http.StatusTooManyRequests, "too many rooms created, try again later")
+		return
+	}
+
 	var req struct {
-		CardSet string `json:"cardSet"`
+		CardSet  string              `json:"cardSet"`
+		Decks    map[string]string   `json:"decks"` // Extra named decks as comma-separated card sets
+		Settings models.RoomSettings `json:"settings"`
 	}
+	req.Settings = models.DefaultRoomSettings()
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.Error("Failed to decode create room request", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	id, err := s.Engine.CreateRoom(req.CardSet)
+	id, err := s.Engine.CreateRoom(req.CardSet, req.Decks, req.Settings)
+	if errors.Is(err, engine.ErrTooManyRooms) {
+		writeError(w, http.StatusServiceUnavailable, "the server is full, try again later")
+		return
+	}
+	var invalid *engine.InvalidRoomError
+	if errors.As(err, &invalid) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
 		slog.Error("Failed to create room", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Reply with the resolved configuration so the client doesn't need another round trip
+	config, ok := s.Engine.Config(id)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "room not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+// HandleGetRoom returns the same room state the WebSocket sends on "updated",
+// so clients can load a room before (or without) opening a socket.
+func (s *Server) HandleGetRoom(w http.ResponseWriter, r *http.Request) {
+	roomId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	// Anonymous requests are read as an observer would see the room, so
+	// votes hidden from observers stay hidden here. The read leaves
+	// LastAccess alone, so polling can't keep an abandoned room alive.
+	view, ok := s.Engine.ViewFor(roomId, "")
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+// HandleGetRoomLog returns the room's recent activity, oldest first, so late
+// joiners can catch up on what happened before they connected
+func (s *Server) HandleGetRoomLog(w http.ResponseWriter, r *http.Request) {
+	roomId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	entries, ok := s.Engine.ActivityLog(roomId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// HandleAddStories appends a JSON array of stories to a room's estimation
+// queue. Only the host, sending their private ID in the X-Player-Id header,
+// or an admin may add stories.
+func (s *Server) HandleAddStories(w http.ResponseWriter, r *http.Request) {
+	roomId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if !s.isAdmin(r) && !s.Engine.IsHost(roomId, r.Header.Get("X-Player-Id")) {
+		writeError(w, http.StatusForbidden, "only the host can add stories")
+		return
+	}
+
+	var stories []models.Story
+	if err := json.NewDecoder(r.Body).Decode(&stories); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.addStories(w, roomId, stories)
+}
+
+// HandleImportGitHub fills a room's estimation queue from the open issues of a
+// GitHub repository, optionally filtered to those carrying every given label
+func (s *Server) HandleImportGitHub(w http.ResponseWriter, r *http.Request) {
+	roomId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	var req struct {
+		Owner  string   `json:"owner"`
+		Repo   string   `json:"repo"`
+		Labels []string `json:"labels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, ok := s.Engine.Settings(roomId); !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+
+	issues, err := s.GitHub.OpenIssues(r.Context(), req.Owner, req.Repo, req.Labels)
+	var rateLimited *github.RateLimitError
+	switch {
+	case errors.Is(err, github.ErrInvalidRepo):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, github.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.As(err, &rateLimited):
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(time.Until(rateLimited.Reset).Seconds()))))
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		slog.Error("GitHub import failed", "error", err, "owner", req.Owner, "repo", req.Repo)
+		writeError(w, http.StatusBadGateway, "github import failed")
+		return
+	}
+
+	if len(issues) == 0 {
+		writeJSON(w, http.StatusOK, map[string]int{"added": 0})
+		return
+	}
+
+	stories := make([]models.Story, 0, len(issues))
+	for _, issue := range issues {
+		stories = append(stories, models.Story{
+			Title:       issue.Title,
+			Description: issue.Body,
+			URL:         issue.URL,
+		})
+	}
+	s.addStories(w, roomId, stories)
+}
+
+// addStories queues stories on the room and replies with how many were added
+func (s *Server) addStories(w http.ResponseWriter, roomId uuid.UUID, stories []models.Story) {
+	total, err := s.Engine.AddStories(roomId, stories)
+	if errors.Is(err, engine.ErrRoomNotFound) {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.broadcastLog(roomId, systemUser, LogStoriesAdded, strconv.Itoa(len(stories)))
+	s.broadcastUpdate(roomId)
+
+	writeJSON(w, http.StatusOK, map[string]int{"added": len(stories), "total": total})
+}
+
+// HandleDeleteRoom lets an operator remove a room straight away. Connected
+// players are told the room was closed before their connections are dropped.
+func (s *Server) HandleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	roomId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	summary, ok := s.Engine.DeleteRoom(roomId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	slog.Info("Room deleted by admin", "roomId", roomId, "remoteAddr", r.RemoteAddr)
+	s.Notifier.Notify(webhook.EventRoomDeleted, summary)
+
+	s.Hub.Publish(HubEvent{
+		RoomId: roomId,
+		Message: models.HubMessage{
+			Type:    models.MessageTypeRoomClosed,
+			Payload: models.RoomClosedMessage{Reason: "This room was closed by an administrator"},
+		},
+	})
+	time.AfterFunc(roomClosingGrace, func() {
+		s.Hub.CloseRoom(roomId)
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAdminGetRoom returns a room with every vote and where each player
+// and connection comes from, for looking into abuse
+func (s *Server) HandleAdminGetRoom(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	roomId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	view, ok := s.Engine.View(roomId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	// Connection details are kept out of views, so they come from a copy of
+	// the room itself
+	room, ok := s.Engine.Snapshot(roomId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "room not found")
+		return
+	}
+	players := make([]models.AdminPlayerView, 0, len(room.Players))
+	for _, p := range room.Players {
+		players = append(players, models.AdminPlayerView{
+			PlayerView: p.View(),
+			Host:       p.PublicId == room.HostId,
+			RemoteAddr: p.RemoteAddr,
+			UserAgent:  p.UserAgent,
+		})
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].PublicId < players[j].PublicId })
+
+	writeJSON(w, http.StatusOK, models.AdminRoomView{
+		Room:        view,
+		Players:     players,
+		Connections: s.Hub.Connections(roomId),
+	})
+}
+
+// isAdmin checks the request carries the configured admin bearer token
+func (s *Server) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.AdminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+// HandleJiraIssue proxies a Jira issue lookup so the client can prefill a
+// story without holding Jira credentials itself
+func (s *Server) HandleJiraIssue(w http.ResponseWriter, r *http.Request) {
+	if s.Jira == nil {
+		writeError(w, http.StatusNotFound, "jira integration is not enabled")
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{"id": id})
+	issue, err := s.Jira.Issue(r.Context(), r.PathValue("key"))
+	switch {
+	case errors.Is(err, jira.ErrInvalidKey):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, jira.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, jira.ErrUnauthorized):
+		slog.Error("Jira rejected credentials", "key", r.PathValue("key"))
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	case err != nil:
+		slog.Error("Jira lookup failed", "error", err, "key", r.PathValue("key"))
+		writeError(w, http.StatusBadGateway, "jira lookup failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, issue)
 }
 
 func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
@@ -121,259 +810,1112 @@ func (s *Server) HandleWS(w http.ResponseWriter, r *http.Request) {
 	roomId, err := uuid.Parse(roomIdStr)
 	if err != nil {
 		slog.Warn("Invalid room ID in WebSocket request", "roomId", roomIdStr)
-		http.Error(w, "invalid room id", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid room id")
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	u := upgrader
+	u.EnableCompression = s.Compression
+	conn, err := u.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("Failed to upgrade connection to WebSocket", "error", err, "roomId", roomId)
 		return
 	}
 
-	slog.Info("WebSocket connection established", "roomId", roomId, "remoteAddr", r.RemoteAddr)
+	conn.SetReadLimit(maxMessageSize)
 
-	client := &Client{Hub: s.Hub, Conn: conn, Send: make(chan []byte, 256), RoomId: roomId}
+	slog.Info("WebSocket connection established", "roomId", roomId, "remoteAddr", r.RemoteAddr, "userAgent", r.UserAgent())
+
+	// ?present opens the room for display only
+	readOnly := r.URL.Query().Has("present")
+	client := &Client{Hub: s.Hub, Conn: conn, Send: make(chan []byte, sendBuffer), RoomId: roomId, ReadOnly: readOnly,
+		RemoteAddr: r.RemoteAddr, UserAgent: r.UserAgent(), pendingReady: make(chan struct{}, 1)}
 	s.Hub.Register <- client
+	s.sendTo(client, models.HubMessage{
+		Type: models.MessageTypeWelcome,
+		Payload: models.WelcomeMessage{
+			ProtocolVersion:    models.ProtocolVersion,
+			MinProtocolVersion: models.MinProtocolVersion,
+			Palette:            models.PlayerPalette,
+		},
+	})
+	if readOnly {
+		// There is no join to send the first update in reply to
+		if _, ok := s.Engine.Settings(roomId); ok {
+			s.sendUpdate(client)
+		} else {
+			s.sendError(client, engine.ErrRoomNotFound)
+		}
+	}
 
 	go client.writePump()
 	go client.readPump(s)
 }
 
+// disconnect marks a player whose connection is gone as asleep and tells the
+// room
+func (s *Server) disconnect(roomId uuid.UUID, privateId string) {
+	player, change, ok := s.Engine.DisconnectPlayer(roomId, privateId)
+	if !ok {
+		return
+	}
+	slog.Info("Player disconnected", "roomId", roomId, "playerName", player.Name)
+	if s.announceRoundChange(roomId, change) {
+		s.broadcastUpdate(roomId)
+	} else {
+		s.broadcastDelta(roomId, &models.HubMessage{
+			Type:    models.MessageTypePlayerUpdated,
+			Payload: player.View(),
+		})
+	}
+}
+
 func (c *Client) readPump(s *Server) {
 	defer func() {
 		if c.PlayerId != "" {
-			if name, ok := s.Engine.DisconnectPlayer(c.RoomId, c.PlayerId); ok {
-				slog.Info("Player disconnected", "roomId", c.RoomId, "playerName", name)
-				s.broadcastUpdate(c.RoomId)
-			}
+			roomId, playerId := c.RoomId, c.PlayerId
+			s.Engine.DisconnectAfterGrace(roomId, playerId, func() {
+				s.disconnect(roomId, playerId)
+			})
 		}
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				slog.Warn("WebSocket message exceeded size limit", "limit", maxMessageSize, "roomId", c.RoomId)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
 				slog.Warn("WebSocket read error", "error", err, "roomId", c.RoomId)
 			}
 			break
 		}
 
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+
 		var req struct {
-			Action  string          `json:"action"`
+			Action  models.Action   `json:"action"`
 			Payload json.RawMessage `json:"payload"`
+			// Optional, echoed back in the ack or error so clients can match them up
+			Id json.RawMessage `json:"id"`
 		}
 		if err := json.Unmarshal(message, &req); err != nil {
 			slog.Warn("Failed to unmarshal WS message", "error", err, "roomId", c.RoomId)
 			continue
 		}
+		if string(req.Id) == "null" {
+			req.Id = nil
+		}
 
-		metrics.WSMessagesReceivedTotal.WithLabelValues(req.Action).Inc()
+		metrics.WSMessagesReceivedTotal.WithLabelValues(string(req.Action)).Inc()
+		c.requestId, c.requestFailed = req.Id, false
 		s.handleAction(c, req.Action, req.Payload)
+		if req.Id != nil && !c.requestFailed {
+			s.sendTo(c, models.HubMessage{
+				Type:    models.MessageTypeAck,
+				Payload: models.AckMessage{Id: req.Id},
+			})
+		}
+		c.requestId = nil
 	}
 }
 
 func (c *Client) writePump() {
-	defer c.Conn.Close()
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
 	for {
 		select {
 		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.write(message); err != nil {
 				slog.Warn("WebSocket write error", "error", err, "roomId", c.RoomId)
 				return
 			}
+			if !c.writePending() {
+				return
+			}
+		case <-c.pendingReady:
+			if !c.writePending() {
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func (s *Server) handleAction(c *Client, action string, payload json.RawMessage) {
-	playerName := s.getPlayerName(c)
-
-	// If player is not recognized and trying to do something other than join, ignore or close
-	if playerName == "Unknown" && action != "join" {
-		return
+// writePending writes the held back room update once the client has caught
+// up with everything queued before it. It reports false on a write error.
+func (c *Client) writePending() bool {
+	message := c.takePending()
+	if message == nil {
+		return true
 	}
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.write(message); err != nil {
+		slog.Warn("WebSocket write error", "error", err, "roomId", c.RoomId)
+		return false
+	}
+	return true
+}
 
-	switch action {
-	case "join":
-		var p struct {
+// write sends a text message, compressing it if it is large enough and the
+// client negotiated compression
+func (c *Client) write(message []byte) error {
+	c.Conn.EnableWriteCompression(len(message) >= compressionThreshold)
+	return c.Conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// roundActions take part in running the round. A room can keep its observers
+// out of them with ObserversChatOnly.
+var roundActions = map[models.Action]bool{
+	models.ActionVote:        true,
+	models.ActionUnvote:      true,
+	models.ActionAbstain:     true,
+	models.ActionOpenVote:    true,
+	models.ActionShow:        true,
+	models.ActionHideVotes:   true,
+	models.ActionClear:       true,
+	models.ActionChangeDeck:  true,
+	models.ActionEditDeck:    true,
+	models.ActionLockVoting:  true,
+	models.ActionNextStory:   true,
+	models.ActionPrevStory:   true,
+	models.ActionSetEstimate: true,
+	models.ActionSkipStory:   true,
+	models.ActionCreateTrack: true,
+	models.ActionJoinTrack:   true,
+}
+
+func (s *Server) handleAction(c *Client, action models.Action, payload json.RawMessage) {
+	// Read-only clients may only ask for the room state again
+	if c.ReadOnly {
+		if action == models.ActionResync {
+			s.sendUpdate(c)
+		} else {
+			s.ignoreAction(c, "This connection is read-only")
+		}
+		return
+	}
+
+	playerName, joined := s.getPlayerName(c)
+
+	// Until the client has a player in the room it can only join. This goes by
+	// the player rather than the name, which may be anything, "Unknown" included.
+	if !joined && action != models.ActionJoin {
+		s.ignoreAction(c, "Join the room first")
+		return
+	}
+
+	if roundActions[action] && s.Engine.ChatOnly(c.RoomId, c.PlayerId) {
+		s.sendErrorCode(c, ErrCodeChatOnly, "Observers can only chat in this room")
+		return
+	}
+
+	// Any action counts as activity and keeps the room from expiring
+	s.Engine.Touch(c.RoomId)
+
+	switch action {
+	case models.ActionJoin:
+		var p struct {
 			Name       string    `json:"name"`
 			RecoveryId uuid.UUID `json:"recoveryId"`
 			Type       string    `json:"type"`
+			// Clients predating versioning don't send one and speak version 1
+			ProtocolVersion int `json:"protocolVersion"`
+			// Receive vote_cast and player_updated deltas instead of some full updates
+			Deltas bool `json:"deltas"`
+			// Optional team, used to break revealed stats down by group
+			Group string `json:"group"`
 		}
-		if err := json.Unmarshal(payload, &p); err != nil {
-			slog.Warn("Join unmarshal error", "error", err, "roomId", c.RoomId)
+		if !s.decodePayload(c, action, payload, &p) {
 			return
 		}
-		player, err := s.Engine.JoinRoom(c.RoomId, p.RecoveryId, p.Name, c.Conn.RemoteAddr().String(), models.PlayerType(p.Type))
-		if err != nil || player == nil {
-			slog.Error("JoinRoom error", "error", err, "playerIsNil", player == nil, "roomId", c.RoomId)
+		if p.ProtocolVersion == 0 {
+			p.ProtocolVersion = 1
+		}
+		if p.ProtocolVersion < models.MinProtocolVersion || p.ProtocolVersion > models.ProtocolVersion {
+			slog.Warn("Rejected incompatible client", "protocolVersion", p.ProtocolVersion, "roomId", c.RoomId)
+			s.sendErrorCode(c, ErrCodeUnsupportedProtocol, fmt.Sprintf(
+				"Client protocol version %d is not supported, the server accepts %d to %d. Please reload the page.",
+				p.ProtocolVersion, models.MinProtocolVersion, models.ProtocolVersion))
 			return
 		}
-		c.PlayerId = player.Id
-		
-		// Send success to client
-		successMsg, _ := json.Marshal(models.HubMessage{
+		var player *models.Player
+		rejoined := false
+		if c.PlayerId != "" {
+			// A second join on the same connection updates the player it
+			// already joined as, rather than registering another one
+			updated, change, err := s.Engine.UpdatePlayer(c.RoomId, c.PlayerId, p.Name, models.PlayerType(p.Type), p.Group)
+			if err != nil && !errors.Is(err, engine.ErrPlayerNotFound) {
+				slog.Warn("Rejoin error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+				s.sendError(c, err)
+				return
+			}
+			if err == nil {
+				player = &updated
+				rejoined = true
+				s.announceRoundChange(c.RoomId, change)
+			}
+		}
+		resumed := false
+		if player == nil {
+			// A player whose connection only just dropped picks up where they
+			// left off, without the room hearing they were ever gone
+			droppedId, ok := s.Engine.CancelDisconnect(c.RoomId, p.RecoveryId)
+			resumed = ok
+			var err error
+			var change engine.RoundChange
+			player, change, err = s.Engine.JoinRoom(c.RoomId, p.RecoveryId, p.Name, models.PlayerType(p.Type), p.Group, c.RemoteAddr)
+			var kicked *engine.KickedError
+			if errors.As(err, &kicked) {
+				s.sendTo(c, models.HubMessage{
+					Type:    models.MessageTypeKicked,
+					Payload: models.KickedMessage{RetryIn: int(math.Ceil(time.Until(kicked.Until).Seconds()))},
+				})
+				return
+			}
+			if err != nil || player == nil {
+				if resumed {
+					s.disconnect(c.RoomId, droppedId)
+				}
+				slog.Error("JoinRoom error", "error", err, "playerIsNil", player == nil, "roomId", c.RoomId)
+				if err != nil {
+					s.sendError(c, err)
+				}
+				return
+			}
+			s.announceRoundChange(c.RoomId, change)
+		}
+		c.setPlayerId(player.Id)
+		c.Deltas.Store(p.Deltas)
+		s.Engine.RecordConnection(c.RoomId, c.PlayerId, c.RemoteAddr, c.UserAgent)
+
+		// Send success to client, followed straight away by the room as this
+		// player may see it. Both go on the client's own queue, so the snapshot
+		// can't fall behind broadcasts still waiting in the hub and a late
+		// joiner sees a revealed round as revealed from the start.
+		s.sendTo(c, models.HubMessage{
 			Type:    models.MessageTypeJoinSuccess,
 			Payload: player,
 		})
-		c.Send <- successMsg
+		s.sendUpdate(c)
+		if settings, ok := s.Engine.Settings(c.RoomId); ok && settings.JoinMessage != "" {
+			s.sendTo(c, models.HubMessage{
+				Type:    models.MessageTypeSystem,
+				Payload: models.SystemMessage{Message: settings.JoinMessage},
+			})
+		}
+		if history := s.Engine.ChatHistory(c.RoomId); len(history) > 0 {
+			s.sendTo(c, models.HubMessage{
+				Type:    models.MessageTypeChatHistory,
+				Payload: history,
+			})
+		}
 
 		s.broadcastUpdate(c.RoomId)
-		s.broadcastLog(c.RoomId, player.Name, "Joined the room")
+		switch {
+		case resumed:
+			// They never visibly left, so there is nothing to announce
+		case !rejoined:
+			s.broadcastLog(c.RoomId, player.Name, LogJoined)
+		case player.Name != playerName:
+			s.broadcastLog(c.RoomId, player.Name, LogRenamed, playerName)
+		}
 
-	case "vote":
+	case models.ActionVote:
 		var p struct {
-			Vote string `json:"vote"`
+			Vote       string            `json:"vote"`
+			Second     string            `json:"second"` // Value on the room's second scale, if it has one
+			Confidence models.Confidence `json:"confidence"`
 		}
-		if err := json.Unmarshal(payload, &p); err != nil {
+		if !s.decodePayload(c, action, payload, &p) {
 			return
 		}
-		if err := s.Engine.Vote(c.RoomId, c.PlayerId, p.Vote); err != nil {
+		result, err := s.Engine.Vote(c.RoomId, c.PlayerId, p.Vote, p.Second, p.Confidence)
+		if err != nil {
 			slog.Warn("Vote error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		if result.Changed {
+			s.broadcastLog(c.RoomId, playerName, LogVoteChanged)
+		} else {
+			s.broadcastLog(c.RoomId, playerName, LogVoted)
+		}
+		if result.AutoRevealed {
+			s.broadcastLog(c.RoomId, systemUser, LogAutoRevealed)
+			s.revealed(c.RoomId)
+			s.broadcastUpdate(c.RoomId)
+		} else {
+			s.broadcastDelta(c.RoomId, voteCast(result.PublicId, true))
+			s.broadcastProgress(c.RoomId, c.PlayerId)
+		}
+
+	case models.ActionAbstain:
+		result, err := s.Engine.Abstain(c.RoomId, c.PlayerId)
+		if err != nil {
+			slog.Warn("Abstain error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogAbstained)
+		if result.AutoRevealed {
+			s.broadcastLog(c.RoomId, systemUser, LogAutoRevealed)
+			s.revealed(c.RoomId)
+			s.broadcastUpdate(c.RoomId)
+		} else {
+			s.broadcastDelta(c.RoomId, voteCast(result.PublicId, true))
+			s.broadcastProgress(c.RoomId, c.PlayerId)
+		}
+
+	case models.ActionUnvote:
+		publicId, err := s.Engine.UnVote(c.RoomId, c.PlayerId)
+		if err != nil {
+			slog.Warn("Unvote error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogVoteWithdrawn)
+		s.broadcastDelta(c.RoomId, voteCast(publicId, false))
+		s.broadcastProgress(c.RoomId, c.PlayerId)
+
+	case models.ActionShow:
+		var p struct {
+			Countdown bool `json:"countdown"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		if p.Countdown {
+			seconds, err := s.Engine.StartCountdown(c.RoomId, c.PlayerId)
+			if err != nil {
+				slog.Warn("Countdown error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+				s.sendError(c, err)
+				return
+			}
+			if seconds > 0 {
+				s.broadcastLog(c.RoomId, playerName, LogCountdownStarted)
+				s.broadcastUpdate(c.RoomId)
+				go s.runCountdown(c.RoomId, playerName, seconds)
+				return
+			}
+		}
+		count, err := s.Engine.ShowVotes(c.RoomId, c.PlayerId)
+		if err != nil {
+			slog.Warn("Show error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		if s.Engine.Opening(c.RoomId, c.PlayerId) {
+			s.broadcastTrackLog(c.RoomId, playerName, s.Engine.PlayerTrack(c.RoomId, c.PlayerId), LogOpening)
+			s.broadcastUpdate(c.RoomId)
+			return
+		}
+		s.logReveal(c.RoomId, playerName, s.Engine.PlayerTrack(c.RoomId, c.PlayerId), count)
+		s.broadcastUpdate(c.RoomId)
+
+	case models.ActionOpenVote:
+		var p struct {
+			Vote  string `json:"vote"`
+			Nonce string `json:"nonce"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		revealed, err := s.Engine.OpenVote(c.RoomId, c.PlayerId, p.Vote, p.Nonce)
+		if err != nil {
+			slog.Warn("Open vote error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		// Nothing others can see changes until the last vote is opened
+		if revealed {
+			s.broadcastTrackLog(c.RoomId, systemUser, s.Engine.PlayerTrack(c.RoomId, c.PlayerId), LogVotesOpened)
+			s.revealed(c.RoomId)
+			s.broadcastUpdate(c.RoomId)
+		}
+
+	case models.ActionHideVotes:
+		if err := s.Engine.HideVotes(c.RoomId, c.PlayerId); err != nil {
+			slog.Warn("Hide votes error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		// Everyone has seen the votes by now, so say so loudly
+		s.broadcastTrackLog(c.RoomId, playerName, s.Engine.PlayerTrack(c.RoomId, c.PlayerId), LogVotesHidden)
+		s.broadcastUpdate(c.RoomId)
+
+	case models.ActionClear:
+		if err := s.Engine.ClearVotes(c.RoomId, c.PlayerId); err != nil {
+			slog.Warn("Clear error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		track := s.Engine.PlayerTrack(c.RoomId, c.PlayerId)
+		s.broadcastTrackLog(c.RoomId, playerName, track, LogVotesCleared)
+		s.broadcastUpdate(c.RoomId)
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{
+			Type:    models.MessageTypeClear,
+			Payload: models.ClearMessage{Track: track},
+		}})
+
+	case models.ActionCreateTrack:
+		var p struct {
+			Name string `json:"name"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		name, err := s.Engine.CreateTrack(c.RoomId, c.PlayerId, p.Name)
+		if err != nil {
+			slog.Warn("Create track error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogTrackOpened, name)
+		s.broadcastUpdate(c.RoomId)
+
+	case models.ActionJoinTrack:
+		var p struct {
+			Name string `json:"name"` // Empty for the main track
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		player, change, err := s.Engine.JoinTrack(c.RoomId, c.PlayerId, p.Name)
+		if err != nil {
+			slog.Warn("Join track error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		if player.Track == "" {
+			s.broadcastLog(c.RoomId, playerName, LogMovedToMain)
+		} else {
+			s.broadcastLog(c.RoomId, playerName, LogMovedToTrack, player.Track)
+		}
+		s.announceRoundChange(c.RoomId, change)
+		s.broadcastUpdate(c.RoomId)
+
+	case models.ActionChangeDeck:
+		var p struct {
+			Deck string `json:"deck"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		if err := s.Engine.ChangeDeck(c.RoomId, c.PlayerId, p.Deck); err != nil {
+			slog.Warn("Change deck error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogDeckChanged, p.Deck)
+		s.broadcastUpdate(c.RoomId)
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+
+	case models.ActionEditDeck:
+		var p struct {
+			Cards string `json:"cards"` // Comma-separated, or a preset name
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		cards, err := s.Engine.EditDeck(c.RoomId, c.PlayerId, p.Cards)
+		if err != nil {
+			slog.Warn("Edit deck error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogCardsChanged, strings.Join(cards, ", "))
+		s.broadcastUpdate(c.RoomId)
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+
+	case models.ActionLockVoting:
+		var p struct {
+			Locked bool `json:"locked"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		if err := s.Engine.SetLocked(c.RoomId, c.PlayerId, p.Locked); err != nil {
+			slog.Warn("Lock voting error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		if p.Locked {
+			s.broadcastLog(c.RoomId, playerName, LogVotingLocked)
+		} else {
+			s.broadcastLog(c.RoomId, playerName, LogVotingUnlocked)
+		}
+		s.broadcastUpdate(c.RoomId)
+
+	case models.ActionNextStory, models.ActionPrevStory:
+		delta := 1
+		if action == models.ActionPrevStory {
+			delta = -1
+		}
+		story, err := s.Engine.MoveStory(c.RoomId, c.PlayerId, delta)
+		if err != nil {
+			slog.Warn("Move story error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogStoryChanged, story.Title)
+		s.broadcastUpdate(c.RoomId)
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+
+	case models.ActionSkipStory:
+		skipped, next, err := s.Engine.SkipStory(c.RoomId, c.PlayerId)
+		if err != nil {
+			slog.Warn("Skip story error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogStorySkipped, skipped.Title)
+		if next != nil {
+			s.broadcastLog(c.RoomId, playerName, LogStoryChanged, next.Title)
+		} else {
+			s.broadcastLog(c.RoomId, systemUser, LogQueueFinished)
+		}
+		s.broadcastUpdate(c.RoomId)
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+
+	case models.ActionSetEstimate:
+		var p struct {
+			Estimate    string `json:"estimate"`
+			AllowCustom bool   `json:"allowCustom"` // Accept a value outside the active deck
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		story, err := s.Engine.SetEstimate(c.RoomId, c.PlayerId, p.Estimate, p.AllowCustom)
+		if err != nil {
+			slog.Warn("Set estimate error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
 			return
 		}
-		s.broadcastLog(c.RoomId, playerName, "Voted")
+		if story.FinalEstimate == "" {
+			s.broadcastLog(c.RoomId, playerName, LogEstimateCleared, story.Title)
+		} else {
+			s.broadcastLog(c.RoomId, playerName, LogEstimated, story.Title, story.FinalEstimate)
+		}
 		s.broadcastUpdate(c.RoomId)
 
-	case "unvote":
-		s.Engine.UnVote(c.RoomId, c.PlayerId)
-		s.broadcastLog(c.RoomId, playerName, "Redacted their vote")
+	case models.ActionUpdateSettings:
+		var p models.SettingsUpdate
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		if _, err := s.Engine.UpdateSettings(c.RoomId, c.PlayerId, p); err != nil {
+			slog.Warn("Update settings error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.broadcastLog(c.RoomId, playerName, LogSettingsUpdated)
 		s.broadcastUpdate(c.RoomId)
 
-	case "show":
-		s.Engine.ShowVotes(c.RoomId)
-		s.broadcastLog(c.RoomId, playerName, "Made all votes visible")
+	case models.ActionKick:
+		var p struct {
+			PublicId int `json:"publicId"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		kickedPrivateId, change, err := s.Engine.KickPlayer(c.RoomId, c.PlayerId, p.PublicId)
+		if err != nil {
+			slog.Warn("Kick error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.kickClient(c.RoomId, kickedPrivateId)
+		s.announceRoundChange(c.RoomId, change)
 		s.broadcastUpdate(c.RoomId)
 
-	case "clear":
-		s.Engine.ClearVotes(c.RoomId)
-		s.broadcastLog(c.RoomId, playerName, "Cleared all votes")
+	case models.ActionKickObservers:
+		kicked, change, err := s.Engine.KickObservers(c.RoomId, c.PlayerId)
+		if err != nil {
+			slog.Warn("Kick observers error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		for _, id := range kicked {
+			s.kickClient(c.RoomId, id)
+		}
+		s.broadcastLog(c.RoomId, playerName, LogObserversRemoved, strconv.Itoa(len(kicked)))
+		s.announceRoundChange(c.RoomId, change)
 		s.broadcastUpdate(c.RoomId)
-		s.Hub.Broadcast <- HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeClear}}
 
-	case "kick":
+	case models.ActionForceObserver:
 		var p struct {
 			PublicId int `json:"publicId"`
 		}
-		json.Unmarshal(payload, &p)
-		kickedPrivateId, err := s.Engine.KickPlayer(c.RoomId, p.PublicId)
-		if err == nil {
-			s.kickClient(c.RoomId, kickedPrivateId)
-			s.broadcastUpdate(c.RoomId)
+		if !s.decodePayload(c, action, payload, &p) {
+			return
 		}
+		target, change, err := s.Engine.ForceObserver(c.RoomId, c.PlayerId, p.PublicId)
+		if err != nil {
+			slog.Warn("Force observer error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.sendToPlayer(c.RoomId, target.Id, models.HubMessage{
+			Type:    models.MessageTypeTypeChanged,
+			Payload: target,
+		})
+		s.broadcastLog(c.RoomId, playerName, LogMadeObserver, target.Name)
+		s.announceRoundChange(c.RoomId, change)
+		s.broadcastUpdate(c.RoomId)
 
-	case "changeType":
+	case models.ActionChangeType:
 		var p struct {
 			Type string `json:"type"`
 		}
-		if err := json.Unmarshal(payload, &p); err != nil {
+		if !s.decodePayload(c, action, payload, &p) {
 			return
 		}
-		
-		server, ok := s.Engine.GetServer(c.RoomId)
-		if !ok { return }
-		player, ok := server.Players[c.PlayerId]
-		if !ok { return }
 
-		player.Type = models.PlayerType(p.Type)
-		// Clear vote if they become an observer
-		if player.Type == models.Observer {
-			s.Engine.UnVote(c.RoomId, c.PlayerId)
+		_, change, err := s.Engine.ChangePlayerType(c.RoomId, c.PlayerId, models.PlayerType(p.Type))
+		if err != nil {
+			slog.Warn("Change type error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
 		}
 
-		s.broadcastLog(c.RoomId, playerName, "Changed their player type to "+p.Type)
+		s.broadcastLog(c.RoomId, playerName, LogTypeChanged, p.Type)
+		s.announceRoundChange(c.RoomId, change)
 		s.broadcastUpdate(c.RoomId)
 
-	case "chat":
+	case models.ActionChat:
 		var p struct {
 			Message string `json:"message"`
 		}
-		if err := json.Unmarshal(payload, &p); err != nil {
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		if err := s.broadcastChat(c.RoomId, playerName, p.Message); err != nil {
+			slog.Warn("Dropped chat message", "playerName", playerName, "length", len(p.Message), "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+
+	case models.ActionPinNote:
+		var p struct {
+			Note string `json:"note"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		note, err := s.Engine.PinNote(c.RoomId, c.PlayerId, p.Note)
+		if err != nil {
+			slog.Warn("Pin note error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		if note == "" {
+			s.broadcastLog(c.RoomId, playerName, LogNoteUnpinned)
+		} else {
+			s.broadcastLog(c.RoomId, playerName, LogNotePinned, note)
+		}
+		s.broadcastUpdate(c.RoomId)
+
+	case models.ActionClearChat:
+		if err := s.Engine.ClearChat(c.RoomId, c.PlayerId); err != nil {
+			slog.Warn("Clear chat error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeChatCleared}})
+		s.broadcastLog(c.RoomId, playerName, LogChatCleared)
+
+	case models.ActionResetRoom:
+		if err := s.Engine.ResetRoom(c.RoomId, c.PlayerId); err != nil {
+			slog.Warn("Reset room error", "playerName", playerName, "error", err, "roomId", c.RoomId)
+			s.sendError(c, err)
+			return
+		}
+		// Clients drop their chat, log and chosen card on room_reset, so it
+		// goes out ahead of the log entry announcing it
+		s.Hub.Publish(HubEvent{RoomId: c.RoomId, Message: models.HubMessage{Type: models.MessageTypeRoomReset}})
+		s.broadcastUpdate(c.RoomId)
+		s.broadcastLog(c.RoomId, playerName, LogRoomReset)
+
+	case models.ActionTyping:
+		var p struct {
+			IsTyping bool `json:"isTyping"`
+		}
+		if !s.decodePayload(c, action, payload, &p) {
+			return
+		}
+		if !s.chatEnabled(c) {
+			return
+		}
+		// Typing indicators are ephemeral: relay to everyone else without logging
+		s.broadcastTyping(c, playerName, p.IsTyping)
+
+	case models.ActionKeepAlive:
+		s.broadcastLog(c.RoomId, playerName, LogKeptOpen)
+
+	case models.ActionResync:
+		if time.Since(c.lastResync) < resyncInterval {
+			s.sendErrorCode(c, ErrCodeRateLimited, "Resync requested too often, try again in a moment")
 			return
 		}
-		s.broadcastChat(c.RoomId, playerName, p.Message)
-	case "leave":
+		c.lastResync = time.Now()
+		// Only the requesting client is out of sync, so don't re-broadcast
+		s.sendUpdate(c)
+
+	case models.ActionLeave:
 		if c.PlayerId != "" {
-			if name, ok := s.Engine.LeaveRoom(c.RoomId, c.PlayerId); ok {
+			if name, change, ok := s.Engine.LeaveRoom(c.RoomId, c.PlayerId); ok {
 				s.broadcastUpdate(c.RoomId)
-				s.broadcastLog(c.RoomId, name, "Left the room")
-				c.PlayerId = "" // Prevent readPump from marking as disconnected
+				s.broadcastLog(c.RoomId, name, LogLeft)
+				s.announceRoundChange(c.RoomId, change)
+				c.setPlayerId("") // Prevent readPump from marking as disconnected
 			}
 		}
+
+	default:
+		s.ignoreAction(c, fmt.Sprintf("Unknown action %q", action))
 	}
 }
 
-func (s *Server) getPlayerName(c *Client) string {
-	if c.PlayerId == "" {
-		return "Unknown"
+// ResetAbandonedRound starts a clean round in a room everyone has left, so
+// whoever comes back doesn't find a stale reveal or half-finished vote. It is
+// meant to be hooked up as Hub.OnRoomEmpty.
+func (s *Server) ResetAbandonedRound(roomId uuid.UUID) {
+	// Give a dropped player the chance to reconnect first
+	time.Sleep(s.Engine.ReconnectGrace)
+	if !s.Engine.ResetAbandonedRound(roomId) {
+		return
+	}
+	// Presentation screens may still be watching
+	s.broadcastUpdate(roomId)
+	s.Hub.Publish(HubEvent{RoomId: roomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+}
+
+// runCountdown broadcasts the remaining seconds of a reveal countdown and then
+// reveals, so every client flips its cards at the same moment. It stops early
+// if the round is cleared or revealed by hand in the meantime.
+func (s *Server) runCountdown(roomId uuid.UUID, playerName string, seconds int) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for remaining := seconds; remaining > 0; remaining-- {
+		if !s.Engine.CountingDown(roomId) {
+			return
+		}
+		s.Hub.Publish(HubEvent{RoomId: roomId, Message: models.HubMessage{
+			Type:    models.MessageTypeCountdown,
+			Payload: models.CountdownMessage{Remaining: remaining},
+		}})
+		<-ticker.C
+	}
+
+	count, err := s.Engine.FinishCountdown(roomId)
+	if errors.Is(err, engine.ErrNoCountdown) || errors.Is(err, engine.ErrRoomNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Countdown reveal error", "error", err, "roomId", roomId)
+		if errors.Is(err, engine.ErrTooFewVotes) {
+			s.broadcastLog(roomId, systemUser, LogCountdownTooFew)
+		} else {
+			s.broadcastLog(roomId, systemUser, LogCountdownNoVotes)
+		}
+		s.broadcastUpdate(roomId)
+		return
+	}
+	if s.Engine.Opening(roomId, "") {
+		s.broadcastLog(roomId, systemUser, LogCountdownOpening)
+		s.broadcastUpdate(roomId)
+		return
 	}
-	server, ok := s.Engine.GetServer(c.RoomId)
+	s.logReveal(roomId, playerName, "", count)
+	s.broadcastUpdate(roomId)
+}
+
+// announceRoundChange tells the room when a player's departure moved the
+// round on by itself. It reports whether the caller should send a full update.
+func (s *Server) announceRoundChange(roomId uuid.UUID, change engine.RoundChange) bool {
+	switch change {
+	case engine.RoundAutoRevealed:
+		s.broadcastLog(roomId, systemUser, LogAutoRevealed)
+		s.revealed(roomId)
+	case engine.RoundReset:
+		s.broadcastLog(roomId, systemUser, LogRoundReset)
+		s.Hub.Publish(HubEvent{RoomId: roomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *Server) logReveal(roomId uuid.UUID, playerName, track string, count int) {
+	if count == 0 {
+		s.broadcastTrackLog(roomId, playerName, track, LogRevealedEmpty)
+	} else {
+		s.broadcastTrackLog(roomId, playerName, track, LogRevealed)
+	}
+	s.revealed(roomId)
+}
+
+// revealed follows up on a round being revealed in the room
+func (s *Server) revealed(roomId uuid.UUID) {
+	s.suggestBreak(roomId)
+	s.scheduleAutoClear(roomId)
+}
+
+// scheduleAutoClear clears the room's revealed main round once the room's
+// AutoClearAfter delay is up. Nothing happens if the round is hidden or
+// cleared by hand first.
+func (s *Server) scheduleAutoClear(roomId uuid.UUID) {
+	delay, revealedAt, ok := s.Engine.AutoClearDue(roomId)
+	if !ok {
+		return
+	}
+	time.AfterFunc(delay, func() {
+		next, ok := s.Engine.AutoClear(roomId, revealedAt)
+		if !ok {
+			return
+		}
+		s.broadcastLog(roomId, systemUser, LogAutoCleared)
+		if next != nil {
+			s.broadcastLog(roomId, systemUser, LogStoryChanged, next.Title)
+		}
+		s.broadcastUpdate(roomId)
+		s.Hub.Publish(HubEvent{RoomId: roomId, Message: models.HubMessage{Type: models.MessageTypeClear}})
+	})
+}
+
+// suggestBreak tells the room to take a break when everyone played ☕
+func (s *Server) suggestBreak(roomId uuid.UUID) {
+	view, ok := s.Engine.View(roomId)
+	if !ok || view.CurrentSession.Stats == nil || !view.CurrentSession.Stats.BreakSuggested {
+		return
+	}
+	s.broadcastLog(roomId, systemUser, LogBreakSuggested)
+}
+
+// chatEnabled reports whether chat is allowed in the client's room, sending
+// the client an error when it is not.
+func (s *Server) chatEnabled(c *Client) bool {
+	settings, ok := s.Engine.Settings(c.RoomId)
 	if !ok {
-		return "Unknown"
+		return false
 	}
-	player, ok := server.Players[c.PlayerId]
+	if !settings.ChatEnabled {
+		s.sendErrorCode(c, ErrCodeChatDisabled, "Chat is disabled in this room")
+		return false
+	}
+	return true
+}
+
+// sendUpdate sends the current room state to a single client
+func (s *Server) sendUpdate(c *Client) {
+	view, ok := s.Engine.ViewFor(c.RoomId, c.PlayerId)
 	if !ok {
-		return "Unknown"
+		return
 	}
-	return player.Name
+	msg, _ := json.Marshal(models.HubMessage{
+		Type:    models.MessageTypeUpdated,
+		Payload: view,
+	})
+	c.queueUpdate(msg)
 }
 
-func (s *Server) broadcastChat(roomId uuid.UUID, user, message string) {
-	s.Hub.Broadcast <- HubEvent{
+// sendTo delivers a message to one client from its own read pump
+func (s *Server) sendTo(c *Client, message models.HubMessage) {
+	msg, _ := json.Marshal(message)
+	c.deliver(msg)
+}
+
+// getPlayerName returns the name of the client's player, and false if it
+// hasn't joined or its player is no longer in the room
+func (s *Server) getPlayerName(c *Client) (string, bool) {
+	if c.PlayerId == "" {
+		return "", false
+	}
+	return s.Engine.PlayerName(c.RoomId, c.PlayerId)
+}
+
+// broadcastChat records a chat message and sends it to the room, returning
+// the engine's error if the message was rejected
+func (s *Server) broadcastChat(roomId uuid.UUID, user, message string) error {
+	chat, err := s.Engine.AddChat(roomId, models.ChatMessage{
+		User:      user,
+		Message:   message,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	s.Hub.Publish(HubEvent{
 		RoomId: roomId,
 		Message: models.HubMessage{
-			Type: models.MessageTypeChat,
-			Payload: models.ChatMessage{
-				User:      user,
-				Message:   message,
-				Timestamp: time.Now(),
+			Type:    models.MessageTypeChat,
+			Payload: chat,
+		},
+	})
+	return nil
+}
+
+func (s *Server) broadcastTyping(sender *Client, user string, isTyping bool) {
+	s.Hub.Publish(HubEvent{
+		RoomId: sender.RoomId,
+		Message: models.HubMessage{
+			Type: models.MessageTypeTyping,
+			Payload: models.TypingMessage{
+				User:     user,
+				IsTyping: isTyping,
 			},
 		},
-	}
+		Exclude: sender,
+	})
 }
 
 func (s *Server) broadcastUpdate(roomId uuid.UUID) {
-	server, _ := s.Engine.GetServer(roomId)
-	s.Hub.Broadcast <- HubEvent{
+	s.broadcastDelta(roomId, nil)
+}
+
+// broadcastDelta sends the full room state, or delta instead to clients that
+// opted into deltas. delta must describe the whole change since the last
+// broadcast. Updates held back by UpdateDebounce follow messages published in
+// the meantime, log entries included, and their views are taken when they go
+// out, so they are never stale.
+func (s *Server) broadcastDelta(roomId uuid.UUID, delta *models.HubMessage) {
+	if s.UpdateDebounce <= 0 {
+		s.publishUpdate(roomId, delta)
+		return
+	}
+
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+	if s.pendingUpdates == nil {
+		s.pendingUpdates = make(map[uuid.UUID]*models.HubMessage)
+	}
+	if _, held := s.pendingUpdates[roomId]; held {
+		// One delta can't describe two changes, so the held update goes out in full
+		s.pendingUpdates[roomId] = nil
+		metrics.WSUpdatesDebouncedTotal.Inc()
+		return
+	}
+	s.pendingUpdates[roomId] = delta
+	time.AfterFunc(s.UpdateDebounce, func() {
+		s.updateMu.Lock()
+		delta := s.pendingUpdates[roomId]
+		delete(s.pendingUpdates, roomId)
+		s.updateMu.Unlock()
+		s.publishUpdate(roomId, delta)
+	})
+}
+
+// publishUpdate hands the room's current state to the hub for broadcast
+func (s *Server) publishUpdate(roomId uuid.UUID, delta *models.HubMessage) {
+	view, redacted, fullViewers, personal, ok := s.Engine.Views(roomId)
+	if !ok {
+		return
+	}
+	event := HubEvent{
 		RoomId: roomId,
 		Message: models.HubMessage{
 			Type:    models.MessageTypeUpdated,
-			Payload: server,
+			Payload: view,
 		},
+		Delta: delta,
 	}
+	if redacted != nil {
+		event.Redacted = &models.HubMessage{
+			Type:    models.MessageTypeUpdated,
+			Payload: redacted,
+		}
+		event.FullViewers = fullViewers
+	}
+	if len(personal) > 0 {
+		event.Personal = make(map[string]*models.HubMessage, len(personal))
+		for privateId, own := range personal {
+			event.Personal[privateId] = &models.HubMessage{
+				Type:    models.MessageTypeUpdated,
+				Payload: own,
+			}
+		}
+	}
+	s.Hub.Publish(event)
+}
+
+// voteCast is the delta for a vote being cast or withdrawn
+func voteCast(publicId int, voted bool) *models.HubMessage {
+	return &models.HubMessage{
+		Type:    models.MessageTypeVoteCast,
+		Payload: models.VoteCastMessage{PublicId: publicId, Voted: voted},
+	}
+}
+
+// broadcastProgress tells the room how far voting has got in a player's track
+func (s *Server) broadcastProgress(roomId uuid.UUID, privateId string) {
+	progress, ok := s.Engine.Progress(roomId, privateId)
+	if !ok {
+		return
+	}
+	s.Hub.Publish(HubEvent{RoomId: roomId, Message: models.HubMessage{
+		Type:    models.MessageTypeProgress,
+		Payload: progress,
+	}})
 }
 
-func (s *Server) broadcastLog(roomId uuid.UUID, user, message string) {
-	s.Hub.Broadcast <- HubEvent{
+// broadcastLog adds an event to the room's activity log. params fill in the
+// event's message from logMessages, in order.
+func (s *Server) broadcastLog(roomId uuid.UUID, user, event string, params ...string) {
+	s.publishLog(roomId, logEntry(user, "", event, params...))
+}
+
+// broadcastTrackLog is broadcastLog for an event in one of the room's tracks
+func (s *Server) broadcastTrackLog(roomId uuid.UUID, user, track, event string, params ...string) {
+	s.publishLog(roomId, logEntry(user, track, event, params...))
+}
+
+func (s *Server) publishLog(roomId uuid.UUID, entry models.LogMessage) {
+	s.Engine.AddLog(roomId, entry)
+	s.Hub.Publish(HubEvent{
 		RoomId: roomId,
 		Message: models.HubMessage{
-			Type: models.MessageTypeLog,
-			Payload: models.LogMessage{
-				User:      user,
-				Message:   message,
-				Timestamp: time.Now(),
-			},
+			Type:    models.MessageTypeLog,
+			Payload: entry,
 		},
-	}
+	})
 }
 
 func (s *Server) kickClient(roomId uuid.UUID, playerId string) {
+	s.sendToPlayer(roomId, playerId, models.HubMessage{
+		Type: models.MessageTypeKicked,
+	})
+}
+
+// sendToPlayer delivers a message to every connection of one player
+func (s *Server) sendToPlayer(roomId uuid.UUID, playerId string, message models.HubMessage) {
 	s.Hub.Mu.RLock()
 	defer s.Hub.Mu.RUnlock()
 
+	msg, _ := json.Marshal(message)
 	for client := range s.Hub.Rooms[roomId] {
 		if client.PlayerId == playerId {
-			// Send kicked message
-			msg, _ := json.Marshal(models.HubMessage{
-				Type: models.MessageTypeKicked,
-			})
 			select {
 			case client.Send <- msg:
 			default:
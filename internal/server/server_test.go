@@ -0,0 +1,1019 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"planning-poker-go/internal/engine"
+	"planning-poker-go/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// harness runs a server with its hub over HTTP for the length of a test
+type harness struct {
+	t      testing.TB
+	s      *Server
+	ts     *httptest.Server
+	url    string // ws:// base URL
+	dialer *websocket.Dialer
+}
+
+func newHarness(t testing.TB) *harness {
+	hub := NewHub()
+	go hub.Run()
+	s := &Server{Engine: engine.NewEngine(), Hub: hub}
+	s.Engine.Connected = hub.ConnectedPlayers
+	s.Engine.ReconnectGrace = 0
+	hub.OnRoomEmpty = s.ResetAbandonedRound
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.HandleWS)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return &harness{t: t, s: s, ts: ts, url: "ws" + strings.TrimPrefix(ts.URL, "http"), dialer: websocket.DefaultDialer}
+}
+
+// room creates a room on the cards 1, 2, 3, 5 and 8, with the default
+// settings unless others are given
+func (h *harness) room(settings *models.RoomSettings) uuid.UUID {
+	h.t.Helper()
+	st := models.DefaultRoomSettings()
+	if settings != nil {
+		st = *settings
+	}
+	id, err := h.s.Engine.CreateRoom("1,2,3,5,8", nil, st)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return id
+}
+
+// wsClient is a test connection that buffers everything the server sends
+type wsClient struct {
+	t    testing.TB
+	conn *websocket.Conn
+	msgs chan *rawMsg
+}
+
+// rawMsg is a server message with its payload left undecoded
+type rawMsg struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Raw     []byte          `json:"-"`
+}
+
+// dial connects to the room. query is appended to the URL.
+func (h *harness) dial(room uuid.UUID, query string) *wsClient {
+	h.t.Helper()
+	c, _, err := h.dialer.Dial(h.url+"/ws?roomId="+room.String()+query, nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Cleanup(func() { c.Close() })
+	w := &wsClient{t: h.t, conn: c, msgs: make(chan *rawMsg, 1024)}
+	go func() {
+		defer close(w.msgs)
+		for {
+			_, b, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			var m rawMsg
+			json.Unmarshal(b, &m)
+			m.Raw = b
+			w.msgs <- &m
+		}
+	}()
+	return w
+}
+
+// join connects and joins the room as a new player, discarding the messages
+// that follow the join
+func (h *harness) join(room uuid.UUID, name string, typ models.PlayerType) *wsClient {
+	h.t.Helper()
+	c := h.dial(room, "")
+	c.send("join", map[string]any{"name": name, "recoveryId": uuid.New(), "type": typ})
+	c.waitFor("join_success")
+	c.drain()
+	return c
+}
+
+func (c *wsClient) send(action string, payload any) {
+	c.sendRaw(map[string]any{"action": action, "payload": payload})
+}
+
+func (c *wsClient) sendRaw(v any) {
+	b, _ := json.Marshal(v)
+	if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next returns the next message, or nil if none arrives within d
+func (c *wsClient) next(d time.Duration) *rawMsg {
+	select {
+	case m := <-c.msgs:
+		return m
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// waitFor discards messages until one of the type arrives
+func (c *wsClient) waitFor(typ string) *rawMsg {
+	c.t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		m := c.next(time.Until(deadline))
+		if m == nil {
+			break
+		}
+		if m.Type == typ {
+			return m
+		}
+	}
+	c.t.Fatalf("no %s message", typ)
+	return nil
+}
+
+// drain returns the messages received until the connection goes quiet
+func (c *wsClient) drain() []*rawMsg {
+	var out []*rawMsg
+	for {
+		m := c.next(100 * time.Millisecond)
+		if m == nil {
+			return out
+		}
+		out = append(out, m)
+	}
+}
+
+// lastView returns the newest room update among the messages received until
+// the connection goes quiet
+func (c *wsClient) lastView() models.ServerView {
+	c.t.Helper()
+	var v models.ServerView
+	got := false
+	for _, m := range c.drain() {
+		if m.Type == "updated" {
+			v = models.ServerView{}
+			json.Unmarshal(m.Payload, &v)
+			got = true
+		}
+	}
+	if !got {
+		c.t.Fatal("no update")
+	}
+	return v
+}
+
+// waitUntil polls until cond holds, failing the test after a few seconds
+func (h *harness) waitUntil(what string, cond func() bool) {
+	h.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// wireConn records the bytes a client reads off the network, so tests can
+// look at frames as the server sent them
+type wireConn struct {
+	net.Conn
+	mu   sync.Mutex
+	read []byte
+}
+
+func (c *wireConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read = append(c.read, p[:n]...)
+	c.mu.Unlock()
+	return n, err
+}
+
+// bytes returns everything read so far
+func (c *wireConn) bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.read...)
+}
+
+// recordWire makes the harness offer compression and record what its next
+// connection reads
+func (h *harness) recordWire() *wireConn {
+	wire := &wireConn{}
+	h.dialer = &websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			wire.Conn = c
+			return wire, err
+		},
+	}
+	return wire
+}
+
+// wireMessage is a message as it went over the wire
+type wireMessage struct {
+	compressed bool
+	payload    []byte // Inflated if it was compressed
+}
+
+// wireMessages reassembles the messages in the bytes read after the handshake
+func wireMessages(t testing.TB, raw []byte) []wireMessage {
+	t.Helper()
+	raw = raw[bytes.Index(raw, []byte("\r\n\r\n"))+4:]
+	var out []wireMessage
+	var payload []byte
+	compressed := false
+	for len(raw) >= 2 {
+		n, head := int(raw[1]&0x7f), 2
+		switch n {
+		case 126:
+			n, head = int(binary.BigEndian.Uint16(raw[2:])), 4
+		case 127:
+			n, head = int(binary.BigEndian.Uint64(raw[2:])), 10
+		}
+		if len(raw) < head+n {
+			break
+		}
+		if raw[0]&0x0f != 0 { // Not a continuation, so the first frame of a message
+			compressed = raw[0]&0x40 != 0
+			payload = nil
+		}
+		payload = append(payload, raw[head:head+n]...)
+		if raw[0]&0x80 != 0 { // Final frame
+			if compressed {
+				// The tail gorilla/websocket also appends for an unshared window
+				r := flate.NewReader(io.MultiReader(bytes.NewReader(payload), strings.NewReader("\x00\x00\xff\xff\x01\x00\x00\xff\xff")))
+				inflated, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("bad compressed message: %v", err)
+				}
+				payload = inflated
+			}
+			out = append(out, wireMessage{compressed: compressed, payload: payload})
+		}
+		raw = raw[head+n:]
+	}
+	return out
+}
+
+func TestTypingRelayedToOthers(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+	a.drain()
+
+	a.send("typing", map[string]any{"isTyping": true})
+	var typing models.TypingMessage
+	json.Unmarshal(b.waitFor("typing").Payload, &typing)
+	if typing != (models.TypingMessage{User: "a", IsTyping: true}) {
+		t.Errorf("got %+v", typing)
+	}
+	for _, m := range a.drain() {
+		if m.Type == "typing" {
+			t.Error("typing indicator echoed to its sender")
+		}
+	}
+}
+
+func TestEmptyRevealAnnounced(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	a.send("show", nil)
+	if m := a.waitFor("log"); !strings.Contains(string(m.Payload), "no one voted") {
+		t.Errorf("want the empty reveal announced, got %s", m.Payload)
+	}
+}
+
+func TestVotesRedactedUntilShown(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.dial(room, "")
+	a.send("join", map[string]any{"name": "a", "recoveryId": uuid.New(), "type": models.Participant})
+	var player models.Player
+	json.Unmarshal(a.waitFor("join_success").Payload, &player)
+	b := h.join(room, "b", models.Participant)
+
+	a.send("vote", map[string]any{"vote": "5"})
+	m := b.waitFor("updated")
+	var v models.ServerView
+	json.Unmarshal(m.Payload, &v)
+	if len(v.CurrentSession.Votes) != 0 || len(v.CurrentSession.Voted) != 1 || v.CurrentSession.Voted[0] != player.PublicId {
+		t.Fatalf("want only that a voted, got %+v", v.CurrentSession)
+	}
+	if raw := string(m.Raw); strings.Contains(raw, player.Id) || strings.Contains(raw, player.RecoveryId.String()) {
+		t.Errorf("update leaks a's private or recovery ID: %s", raw)
+	}
+
+	a.send("show", nil)
+	if v := b.lastView(); v.CurrentSession.Votes[fmt.Sprint(player.PublicId)] != "5" {
+		t.Errorf("want a's vote once shown, got %+v", v.CurrentSession)
+	}
+}
+
+func TestChatDisabled(t *testing.T) {
+	h := newHarness(t)
+	settings := models.DefaultRoomSettings()
+	settings.ChatEnabled = false
+	room := h.room(&settings)
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+	a.drain()
+
+	for _, action := range []string{"chat", "typing"} {
+		a.send(action, map[string]any{"message": "hi", "isTyping": true})
+		var refused models.ErrorMessage
+		json.Unmarshal(a.waitFor("error").Payload, &refused)
+		if refused.Code != ErrCodeChatDisabled || refused.Message == "" {
+			t.Errorf("%s refused with %+v", action, refused)
+		}
+	}
+	for _, m := range b.drain() {
+		if m.Type == "chat" || m.Type == "typing" {
+			t.Errorf("%s relayed with chat disabled", m.Type)
+		}
+	}
+	if history := h.s.Engine.ChatHistory(room); len(history) != 0 {
+		t.Errorf("chat kept with chat disabled: %+v", history)
+	}
+}
+
+func TestChatEnabled(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+
+	a.send("chat", map[string]any{"message": "hi"})
+	var chat models.ChatMessage
+	json.Unmarshal(b.waitFor("chat").Payload, &chat)
+	if chat.User != "a" || chat.Message != "hi" {
+		t.Errorf("got %+v", chat)
+	}
+	if history := h.s.Engine.ChatHistory(room); len(history) != 1 || history[0].Message != "hi" {
+		t.Errorf("want the message kept for late joiners, got %+v", history)
+	}
+}
+
+
+
+func TestResyncOnlyToRequester(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+	b.send("vote", map[string]any{"vote": "3"})
+	b.drain()
+	a.drain()
+
+	a.send("resync", nil)
+	var v models.ServerView
+	json.Unmarshal(a.waitFor("updated").Payload, &v)
+	if len(v.Players) != 2 || len(v.CurrentSession.Voted) != 1 {
+		t.Errorf("resync is out of date: %+v", v)
+	}
+	if msgs := b.drain(); len(msgs) != 0 {
+		t.Errorf("resync broadcast to the room: %d messages", len(msgs))
+	}
+}
+
+func TestIdleRoomClosing(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	empty := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+
+	h.s.CleanupOldRooms(time.Hour, 2*time.Hour)
+	var warning models.IdleWarningMessage
+	json.Unmarshal(a.waitFor("idle_warning").Payload, &warning)
+	if warning.ExpiresIn <= 0 || warning.ExpiresIn > 3600 {
+		t.Errorf("want about an hour left, got %ds", warning.ExpiresIn)
+	}
+
+	h.s.CleanupOldRooms(0, 0)
+	if _, ok := h.s.Engine.View(empty); ok {
+		t.Error("room without clients not removed at once")
+	}
+	var closed models.RoomClosedMessage
+	json.Unmarshal(a.waitFor("room_closed").Payload, &closed)
+	if closed.Reason == "" {
+		t.Error("room closed without a reason")
+	}
+	if _, ok := h.s.Engine.View(room); !ok {
+		t.Fatal("room removed before its clients had a chance to react")
+	}
+
+	time.Sleep(roomClosingGrace + 200*time.Millisecond)
+	if _, ok := h.s.Engine.View(room); ok {
+		t.Error("room kept after the grace period")
+	}
+	select {
+	case m, open := <-a.msgs:
+		if open {
+			t.Errorf("want the connection closed, got %s", m.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("connection still open")
+	}
+}
+
+func TestDeltaUpdates(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	full := h.join(room, "full", models.Participant)
+	d := h.dial(room, "")
+	d.send("join", map[string]any{"name": "delta", "recoveryId": uuid.New(), "deltas": true})
+	d.waitFor("join_success")
+	d.drain()
+	full.drain()
+
+	full.send("vote", map[string]any{"vote": "3"})
+	var cast models.VoteCastMessage
+	json.Unmarshal(d.waitFor("vote_cast").Payload, &cast)
+	if !cast.Voted || cast.PublicId == 0 {
+		t.Errorf("want the vote announced, got %+v", cast)
+	}
+	full.send("unvote", nil)
+	json.Unmarshal(d.waitFor("vote_cast").Payload, &cast)
+	if cast.Voted {
+		t.Errorf("want the withdrawal announced, got %+v", cast)
+	}
+	for _, m := range d.drain() {
+		if m.Type == "updated" {
+			t.Error("delta client sent a full update for a vote")
+		}
+	}
+	if v := full.lastView(); len(v.Players) != 2 {
+		t.Errorf("full client's update: %+v", v)
+	}
+
+	// Every so often a delta client is brought back in step with a full update
+	got := false
+	for i := 0; i <= fullSyncEvery && !got; i++ {
+		full.send("vote", map[string]any{"vote": "5"})
+		for _, m := range d.drain() {
+			got = got || m.Type == "updated"
+		}
+	}
+	if !got {
+		t.Errorf("no full update after %d deltas", fullSyncEvery)
+	}
+}
+
+// BenchmarkUpdateSize compares what one vote costs to send to each client of
+// a 30-player room as a full update and as a delta
+func BenchmarkUpdateSize(b *testing.B) {
+	s := &Server{Engine: engine.NewEngine()}
+	room, err := s.Engine.CreateRoom("1,2,3,5,8,13", nil, models.DefaultRoomSettings())
+	if err != nil {
+		b.Fatal(err)
+	}
+	var last *models.Player
+	for i := 0; i < 30; i++ {
+		last, _, _ = s.Engine.JoinRoom(room, uuid.Nil, fmt.Sprintf("Player %d", i), models.Participant, "", "")
+		if i < 29 {
+			s.Engine.Vote(room, last.Id, "3", "", "")
+		}
+	}
+
+	b.Run("full", func(b *testing.B) {
+		size := 0
+		for i := 0; i < b.N; i++ {
+			view, _ := s.Engine.View(room)
+			msg, _ := json.Marshal(models.HubMessage{Type: models.MessageTypeUpdated, Payload: view})
+			size = len(msg)
+		}
+		b.ReportMetric(float64(size), "bytes/update")
+	})
+	b.Run("delta", func(b *testing.B) {
+		size := 0
+		for i := 0; i < b.N; i++ {
+			msg, _ := json.Marshal(voteCast(last.PublicId, true))
+			size = len(msg)
+		}
+		b.ReportMetric(float64(size), "bytes/update")
+	})
+	for _, compression := range []bool{false, true} {
+		b.Run(fmt.Sprintf("40 players/compression=%v", compression), func(b *testing.B) {
+			benchmarkWireUpdates(b, compression)
+		})
+	}
+}
+
+// benchmarkWireUpdates reports the bytes a client reads off the wire for each
+// full update of a room of 40 voters
+func benchmarkWireUpdates(b *testing.B, compression bool) {
+	h := newHarness(b)
+	h.s.Compression = compression
+	room := h.room(nil)
+	for i := 0; i < 40; i++ {
+		p, _, err := h.s.Engine.JoinRoom(room, uuid.Nil, fmt.Sprintf("Player %d", i), models.Participant, "", "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		h.s.Engine.Vote(room, p.Id, "3", "", "")
+	}
+	wire := h.recordWire()
+	c := h.join(room, "observer", models.Observer)
+
+	start := len(wire.bytes())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.s.broadcastUpdate(room)
+		c.waitFor("updated")
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(len(wire.bytes())-start)/float64(b.N), "bytes/update")
+}
+
+func TestCoalesceUpdates(t *testing.T) {
+	c := &Client{Send: make(chan []byte, sendBuffer), pendingReady: make(chan struct{}, 1)}
+	c.queueUpdate([]byte("first"))
+	if len(c.Send) != 1 || c.pending != nil {
+		t.Fatal("update held back from a client that is keeping up")
+	}
+	for len(c.Send) < coalesceThreshold {
+		c.Send <- []byte("chat")
+	}
+
+	c.queueUpdate([]byte("a"))
+	c.queueUpdate([]byte("b"))
+	if len(c.Send) != coalesceThreshold || string(c.pending) != "b" {
+		t.Fatalf("want only the newest update held back, got %q", c.pending)
+	}
+	if c.queueDelta([]byte("delta")) {
+		t.Error("delta queued ahead of a held back update")
+	}
+	if c.takePending() != nil {
+		t.Error("held back update overtook the queue")
+	}
+	for len(c.Send) > 0 {
+		<-c.Send
+	}
+	if got := c.takePending(); string(got) != "b" || c.pending != nil {
+		t.Errorf("want the held back update once the queue drained, got %q", got)
+	}
+}
+
+func TestSlowClientCatchesUp(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	conn, _, err := websocket.DefaultDialer.Dial(h.url+"/ws?roomId="+room.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]any{"action": "join", "payload": map[string]any{"name": "slow"}})
+	time.Sleep(200 * time.Millisecond)
+
+	// Far more updates than the client's buffer and the socket hold, while it
+	// isn't reading
+	const sent = 3000
+	h.s.Engine.AddStories(room, []models.Story{{Title: strings.Repeat("x", 5000)}})
+	for i := 0; i < sent; i++ {
+		h.s.broadcastUpdate(room)
+	}
+	h.s.Engine.AddStories(room, []models.Story{{Title: "final"}})
+	h.s.broadcastUpdate(room)
+	time.Sleep(300 * time.Millisecond)
+
+	updates, last := 0, ""
+	for {
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			if !strings.Contains(err.Error(), "timeout") {
+				t.Fatalf("slow client dropped: %v", err)
+			}
+			break
+		}
+		var m rawMsg
+		json.Unmarshal(b, &m)
+		if m.Type == "updated" {
+			updates++
+			last = string(b)
+		}
+	}
+	if !strings.Contains(last, `"final"`) {
+		t.Error("last update isn't the newest state")
+	}
+	if updates > sent {
+		t.Errorf("no updates coalesced: got %d", updates)
+	}
+}
+
+func TestJoinMidReveal(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	a.send("vote", map[string]any{"vote": "5"})
+	a.send("show", nil)
+	if v := a.lastView(); !v.CurrentSession.IsShown {
+		t.Fatal("round not revealed")
+	}
+
+	o := h.dial(room, "")
+	o.send("join", map[string]any{"name": "o", "recoveryId": uuid.New(), "type": models.Observer})
+	var joined bool
+	var m *rawMsg
+	for m = o.next(time.Second); m != nil && m.Type != "updated"; m = o.next(time.Second) {
+		joined = joined || m.Type == "join_success"
+	}
+	if m == nil || !joined {
+		t.Fatalf("want the room state after join_success, got %+v", m)
+	}
+	var v models.ServerView
+	json.Unmarshal(m.Payload, &v)
+	if !v.CurrentSession.IsShown || len(v.CurrentSession.Votes) != 1 || v.CurrentSession.Stats == nil || v.CurrentSession.Stats.Average != 5 {
+		t.Errorf("want the revealed round straight after joining, got %+v", v.CurrentSession)
+	}
+}
+
+func TestAbandonedRoundReset(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	h.s.Engine.AddStories(room, []models.Story{{Title: "one"}})
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+	a.send("vote", map[string]any{"vote": "5"})
+	a.send("show", nil)
+	a.drain()
+
+	a.conn.Close()
+	time.Sleep(200 * time.Millisecond)
+	if v, _ := h.s.Engine.View(room); !v.CurrentSession.IsShown {
+		t.Fatal("round reset while a player is still connected")
+	}
+
+	b.conn.Close()
+	h.waitUntil("the round is reset", func() bool {
+		v, _ := h.s.Engine.View(room)
+		return !v.CurrentSession.IsShown && len(v.CurrentSession.Voted) == 0
+	})
+	if v, _ := h.s.Engine.View(room); len(v.CurrentSession.CardSet) != 5 || len(v.Stories) != 1 {
+		t.Errorf("want the deck and stories kept, got %+v", v)
+	}
+}
+
+// TestActionsNeedAPlayer checks that actions go by whether the client has a
+// player in the room, not by its name
+func TestActionsNeedAPlayer(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	u := h.join(room, "Unknown", models.Participant)
+	u.sendRaw(map[string]any{"action": "vote", "payload": map[string]any{"vote": "3"}, "id": 1})
+	u.waitFor("ack")
+
+	x := h.dial(room, "")
+	x.drain()
+	x.sendRaw(map[string]any{"action": "vote", "payload": map[string]any{"vote": "5"}, "id": 2})
+	if m := x.waitFor("error"); !strings.Contains(string(m.Payload), ErrCodeInvalidRequest) {
+		t.Errorf("want the unjoined vote refused, got %s", m.Payload)
+	}
+
+	u.send("leave", nil)
+	u.drain()
+	u.sendRaw(map[string]any{"action": "vote", "payload": map[string]any{"vote": "8"}, "id": 3})
+	u.waitFor("error")
+
+	if _, err := h.s.Engine.Vote(room, "", "3", "", ""); !errors.Is(err, engine.ErrPlayerNotFound) {
+		t.Errorf("want a vote without a player refused, got %v", err)
+	}
+	if v, _ := h.s.Engine.View(room); len(v.CurrentSession.Voted) != 0 || len(v.Players) != 0 {
+		t.Errorf("want no players or votes left, got %+v", v)
+	}
+}
+
+func TestActionsWithoutAName(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	c := h.dial(room, "")
+	c.send("join", map[string]any{"name": "", "recoveryId": uuid.New(), "type": models.Participant})
+	var player models.Player
+	json.Unmarshal(c.waitFor("join_success").Payload, &player)
+	if player.Name == "" {
+		t.Fatal("joined without a name")
+	}
+	c.sendRaw(map[string]any{"action": "vote", "payload": map[string]any{"vote": "3"}, "id": 1})
+	c.waitFor("ack")
+}
+
+func TestSmallMessagesUncompressed(t *testing.T) {
+	h := newHarness(t)
+	h.s.Compression = true
+	room := h.room(nil)
+	h.s.Engine.AddStories(room, []models.Story{{Title: strings.Repeat("x", 2*compressionThreshold)}})
+	wire := h.recordWire()
+	a := h.join(room, "a", models.Participant)
+	a.send("chat", map[string]any{"message": "hi"})
+	a.waitFor("chat")
+	a.drain()
+
+	small, large := 0, 0
+	for _, m := range wireMessages(t, wire.bytes()) {
+		if m.compressed != (len(m.payload) >= compressionThreshold) {
+			t.Errorf("message of %d bytes sent with compression %v: %.40s", len(m.payload), m.compressed, m.payload)
+		}
+		if m.compressed {
+			large++
+		} else {
+			small++
+		}
+	}
+	if small == 0 || large == 0 {
+		t.Errorf("want both small and large messages, got %d small and %d large", small, large)
+	}
+}
+
+func TestOwnVoteVisible(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+	screen := h.dial(room, "&present")
+	a.send("vote", map[string]any{"vote": "5"})
+	b.send("vote", map[string]any{"vote": "3"})
+	time.Sleep(100 * time.Millisecond)
+
+	va, vb, vs := a.lastView(), b.lastView(), screen.lastView()
+	if va.CurrentSession.IsShown || len(va.CurrentSession.Voted) != 2 {
+		t.Fatalf("want an unrevealed round with two votes, got %+v", va.CurrentSession)
+	}
+	if len(va.CurrentSession.Votes) != 1 || va.CurrentSession.Votes["1"] != "5" {
+		t.Errorf("a sees %v", va.CurrentSession.Votes)
+	}
+	if len(vb.CurrentSession.Votes) != 1 || vb.CurrentSession.Votes["2"] != "3" {
+		t.Errorf("b sees %v", vb.CurrentSession.Votes)
+	}
+	if len(vs.CurrentSession.Votes) != 0 {
+		t.Errorf("shared screen sees %v", vs.CurrentSession.Votes)
+	}
+	a.send("resync", nil)
+	if v := a.lastView(); len(v.CurrentSession.Votes) != 1 || v.CurrentSession.Votes["1"] != "5" {
+		t.Errorf("a's resync shows %v", v.CurrentSession.Votes)
+	}
+
+	a.send("updateSettings", map[string]any{"hideOwnVote": true})
+	if v := a.lastView(); len(v.CurrentSession.Votes) != 0 {
+		t.Errorf("own vote shown with hideOwnVote: %v", v.CurrentSession.Votes)
+	}
+}
+
+func TestOwnVoteSealed(t *testing.T) {
+	h := newHarness(t)
+	st := models.DefaultRoomSettings()
+	st.CommitReveal = true
+	room := h.room(&st)
+	a := h.join(room, "a", models.Participant)
+	a.send("vote", map[string]any{"vote": "ff9e51c3e3f72ab265dedd150f6d56bacd040f6df93f0ed7cbdc1c002e503379"})
+	if v := a.lastView(); len(v.CurrentSession.Votes) != 0 || len(v.CurrentSession.Voted) != 1 {
+		t.Errorf("want a sealed vote kept hidden from its voter, got %+v", v.CurrentSession)
+	}
+}
+
+// TestConcurrentJoinVoteLeave drives many connections through the read pump
+// at once while the hub broadcasts to them, and is meant to be run with -race
+func TestConcurrentJoinVoteLeave(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	host := h.join(room, "host", models.Participant)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				c, _, err := websocket.DefaultDialer.Dial(h.url+"/ws?roomId="+room.String(), nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				send := func(action string, payload any) {
+					b, _ := json.Marshal(map[string]any{"action": action, "payload": payload})
+					c.WriteMessage(websocket.TextMessage, b)
+				}
+				send("join", map[string]any{"name": fmt.Sprintf("p%d-%d", i, j), "recoveryId": uuid.New()})
+				send("vote", map[string]any{"vote": "3"})
+				send("changeType", map[string]any{"type": "Observer"})
+				send("changeType", map[string]any{"type": "Participant"})
+				send("vote", map[string]any{"vote": "5"})
+				send("leave", nil)
+				time.Sleep(10 * time.Millisecond)
+				c.Close()
+			}
+		}(i)
+	}
+	// The host's connection reads the broadcasts and kicks whoever it can
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for publicId := 2; publicId < 40; publicId += 7 {
+				host.send("kick", map[string]any{"publicId": publicId})
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		v, _ := h.s.Engine.View(room)
+		if len(v.Players) == 1 && len(v.CurrentSession.Voted) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want only the host left with no votes, got %d players and votes from %v", len(v.Players), v.CurrentSession.Voted)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// logEvents returns the event types in the room's activity log, oldest first
+func (h *harness) logEvents(room uuid.UUID) []string {
+	log, _ := h.s.Engine.ActivityLog(room)
+	events := make([]string, 0, len(log))
+	for _, entry := range log {
+		events = append(events, entry.EventType)
+	}
+	return events
+}
+
+func TestAutoClear(t *testing.T) {
+	h := newHarness(t)
+	st := models.DefaultRoomSettings()
+	st.AutoClearAfter = 1
+	room := h.room(&st)
+	h.s.Engine.AddStories(room, []models.Story{{Title: "one"}, {Title: "two"}})
+	a := h.join(room, "a", models.Participant)
+	a.send("vote", map[string]any{"vote": "5"})
+	a.send("show", nil)
+	if v := a.lastView(); !v.CurrentSession.IsShown {
+		t.Fatal("round not revealed")
+	}
+
+	a.waitFor("clear")
+	v, _ := h.s.Engine.View(room)
+	if v.CurrentSession.IsShown || len(v.CurrentSession.Voted) != 0 || v.StoryIndex != 1 {
+		t.Fatalf("want a fresh round on the next story, got story %d and %+v", v.StoryIndex, v.CurrentSession)
+	}
+	if events := strings.Join(h.logEvents(room), ","); !strings.Contains(events, LogAutoCleared+","+LogStoryChanged) {
+		t.Errorf("log is missing the auto-clear: %s", events)
+	}
+}
+
+func TestAutoClearCancelledByManualClear(t *testing.T) {
+	h := newHarness(t)
+	st := models.DefaultRoomSettings()
+	st.AutoClearAfter = 1
+	room := h.room(&st)
+	a := h.join(room, "a", models.Participant)
+
+	a.send("vote", map[string]any{"vote": "3"})
+	a.send("show", nil)
+	time.Sleep(600 * time.Millisecond)
+	a.send("clear", nil)
+	time.Sleep(100 * time.Millisecond)
+	a.send("vote", map[string]any{"vote": "8"})
+	a.send("show", nil)
+
+	// The first reveal's timer is due now, but that round was cleared by hand
+	time.Sleep(500 * time.Millisecond)
+	if v, _ := h.s.Engine.View(room); !v.CurrentSession.IsShown {
+		t.Fatal("second round cleared by the first round's timer")
+	}
+	h.waitUntil("the second round is cleared", func() bool {
+		v, _ := h.s.Engine.View(room)
+		return !v.CurrentSession.IsShown
+	})
+}
+
+func TestAutoClearAfterPlayerLeavesDuringReveal(t *testing.T) {
+	h := newHarness(t)
+	st := models.DefaultRoomSettings()
+	st.AutoClearAfter = 1
+	room := h.room(&st)
+	a := h.join(room, "a", models.Participant)
+	b := h.join(room, "b", models.Participant)
+	a.send("vote", map[string]any{"vote": "3"})
+	b.send("vote", map[string]any{"vote": "5"})
+	a.drain()
+	a.send("show", nil)
+	a.drain()
+
+	// Leaving recomputes the revealed round's stats
+	b.send("leave", nil)
+	h.waitUntil("b has left", func() bool {
+		v, _ := h.s.Engine.View(room)
+		return len(v.Players) == 1
+	})
+	if v, _ := h.s.Engine.View(room); !v.CurrentSession.IsShown || v.CurrentSession.Stats.Average != 3 {
+		t.Fatalf("want the round still revealed with a's vote alone, got %+v", v.CurrentSession)
+	}
+	h.waitUntil("the round is cleared", func() bool {
+		v, _ := h.s.Engine.View(room)
+		return !v.CurrentSession.IsShown
+	})
+}
+
+func TestAutoClearSettingBounds(t *testing.T) {
+	h := newHarness(t)
+	room := h.room(nil)
+	a := h.join(room, "a", models.Participant)
+	a.send("updateSettings", map[string]any{"autoClearAfter": 11 * 60})
+	if m := a.waitFor("error"); !strings.Contains(string(m.Payload), "auto-clear") {
+		t.Errorf("want the auto-clear delay rejected, got %s", m.Payload)
+	}
+}
+
+func TestAddStoriesNeedsHost(t *testing.T) {
+	h := newHarness(t)
+	h.s.AdminToken = "secret"
+	room := h.room(nil)
+	host, _, _ := h.s.Engine.JoinRoom(room, uuid.Nil, "host", models.Participant, "", "")
+	guest, _, _ := h.s.Engine.JoinRoom(room, uuid.Nil, "guest", models.Participant, "", "")
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"anonymous", "", "", http.StatusForbidden},
+		{"guest", "X-Player-Id", guest.Id, http.StatusForbidden},
+		{"wrong token", "Authorization", "Bearer nope", http.StatusForbidden},
+		{"host", "X-Player-Id", host.Id, http.StatusOK},
+		{"admin", "Authorization", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/rooms/"+room.String()+"/stories", strings.NewReader(`[{"title":"one"}]`))
+			r.SetPathValue("id", room.String())
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			h.s.HandleAddStories(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+	if v, _ := h.s.Engine.View(room); len(v.Stories) != 2 {
+		t.Errorf("want the 2 authorised stories queued, got %d", len(v.Stories))
+	}
+}
+
+func TestCreateRoomStatus(t *testing.T) {
+	h := newHarness(t)
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"valid", `{"cardSet":"1,2,3"}`, http.StatusOK},
+		{"malformed", `{"cardSet":`, http.StatusBadRequest},
+		{"empty deck", `{"cardSet":" , "}`, http.StatusBadRequest},
+		{"unnamed deck", `{"cardSet":"1,2","decks":{" ":"1,2"}}`, http.StatusBadRequest},
+		{"duplicate deck", `{"cardSet":"1,2","decks":{"default":"3,5"}}`, http.StatusBadRequest},
+		{"bad settings", `{"cardSet":"1,2","settings":{"kickCooldown":-1}}`, http.StatusBadRequest},
+		{"unknown second scale", `{"cardSet":"1,2","settings":{"secondScale":"nope"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/create", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.s.HandleCreateRoom(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
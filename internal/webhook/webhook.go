@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"planning-poker-go/internal/models"
+)
+
+type EventType string
+
+const (
+	EventRoomExpired EventType = "room_expired"
+	EventRoomDeleted EventType = "room_deleted" // Removed by an operator
+)
+
+type Event struct {
+	Type      EventType           `json:"type"`
+	Timestamp time.Time           `json:"timestamp"`
+	Room      *models.RoomSummary `json:"room"`
+}
+
+// Notifier POSTs room lifecycle events to an external webhook. Deliveries run
+// in the background and are retried with exponential backoff, so callers are
+// never held up by a slow or failing endpoint.
+type Notifier struct {
+	URL        string
+	Client     *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+func NewNotifier(url string) *Notifier {
+	return &Notifier{
+		URL:        url,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		MaxRetries: 3,
+		Backoff:    time.Second,
+	}
+}
+
+// Notify sends the event asynchronously. It is a no-op on a nil Notifier so
+// deployments without a webhook don't need to check.
+func (n *Notifier) Notify(eventType EventType, room *models.RoomSummary) {
+	if n == nil {
+		return
+	}
+	event := Event{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Room:      room,
+	}
+	go n.deliver(event)
+}
+
+func (n *Notifier) deliver(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal webhook event", "error", err, "type", event.Type)
+		return
+	}
+
+	backoff := n.Backoff
+	for attempt := 0; ; attempt++ {
+		err = n.post(body)
+		if err == nil {
+			slog.Info("Webhook delivered", "type", event.Type, "roomId", event.Room.RoomId)
+			return
+		}
+		if attempt >= n.MaxRetries {
+			break
+		}
+		slog.Warn("Webhook delivery failed, retrying", "error", err, "type", event.Type, "attempt", attempt+1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	slog.Error("Webhook delivery gave up", "error", err, "type", event.Type, "roomId", event.Room.RoomId)
+}
+
+func (n *Notifier) post(body []byte) error {
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"planning-poker-go/internal/engine"
+	"planning-poker-go/internal/models"
+	"planning-poker-go/internal/server"
+	"planning-poker-go/internal/webhook"
+
+	"github.com/google/uuid"
+)
+
+// receiver is a webhook endpoint that fails the first failures requests and
+// hands every body it is sent to the test
+type receiver struct {
+	failures int32
+	attempts atomic.Int32
+	bodies   chan []byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.bodies <- body
+	if r.attempts.Add(1) <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// expire sets up a server with one revealed room whose expiry is reported to
+// a receiver failing the given number of times, and runs cleanup
+func expire(t *testing.T, failures int32, retries int) (*receiver, uuid.UUID) {
+	t.Helper()
+	r := &receiver{failures: failures, bodies: make(chan []byte, 16)}
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	s := &server.Server{Engine: engine.NewEngine(), Hub: server.NewHub()}
+	s.Notifier = webhook.NewNotifier(ts.URL)
+	s.Notifier.MaxRetries = retries
+	s.Notifier.Backoff = 10 * time.Millisecond
+
+	id, err := s.Engine.CreateRoom("1,2,3", nil, models.DefaultRoomSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	player, _, err := s.Engine.JoinRoom(id, uuid.New(), "a", models.Participant, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Engine.Vote(id, player.Id, "3", "", "")
+	s.Engine.ShowVotes(id, player.Id)
+
+	s.CleanupOldRooms(0, 0)
+	return r, id
+}
+
+// event waits for the next delivery attempt
+func (r *receiver) event(t *testing.T) webhook.Event {
+	t.Helper()
+	select {
+	case body := <-r.bodies:
+		var event webhook.Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("bad body %s: %v", body, err)
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no webhook delivery")
+		return webhook.Event{}
+	}
+}
+
+// quiet fails the test if another delivery attempt arrives
+func (r *receiver) quiet(t *testing.T) {
+	t.Helper()
+	select {
+	case body := <-r.bodies:
+		t.Errorf("unexpected delivery %s", body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRoomExpiredDelivered(t *testing.T) {
+	r, id := expire(t, 0, 3)
+	event := r.event(t)
+	if event.Type != webhook.EventRoomExpired {
+		t.Errorf("want a room_expired event, got %q", event.Type)
+	}
+	if event.Room == nil || event.Room.RoomId != id {
+		t.Fatalf("want room %s, got %+v", id, event.Room)
+	}
+	if event.Room.Players != 1 || event.Room.Votes["1"] != "3" {
+		t.Errorf("want the room's final summary, got %+v", event.Room)
+	}
+	r.quiet(t)
+}
+
+func TestRoomExpiredRetried(t *testing.T) {
+	r, id := expire(t, 2, 3)
+	for i := 0; i < 3; i++ {
+		if event := r.event(t); event.Room == nil || event.Room.RoomId != id {
+			t.Fatalf("attempt %d: want room %s, got %+v", i+1, id, event.Room)
+		}
+	}
+	r.quiet(t)
+}
+
+func TestRoomExpiredGivesUp(t *testing.T) {
+	r, _ := expire(t, 10, 2)
+	for i := 0; i < 3; i++ {
+		r.event(t)
+	}
+	r.quiet(t)
+}
@@ -6,24 +6,101 @@ type PlayerMode = 'Awake' | 'Asleep';
 
 interface Player {
   publicId: number;
+  recoveryId?: string;
   name: string;
   type: PlayerType;
   mode: PlayerMode;
+  group?: string;
+  color: string; // Assigned by the server from its palette
+  online: boolean; // Awake with a live connection
+  track?: string; // Absent for the main track
+}
+
+interface Session {
+  cardSet: string[];
+  decks: Record<string, string[]>;
+  activeDeck: string;
+  votes: Record<string, string>; // Empty until votes are shown
+  voted: number[];
+  isShown: boolean;
+  locked: boolean;
+  countingDown: boolean;
+  stats?: VoteStats;
+  distribution?: Record<string, number>;
+  voteOrder?: { publicId: number; votedAt: string }[];
+  groupStats?: Record<string, VoteStats>;
+  secondVotes?: Record<string, string>; // Sent on reveal in a dual-scale room
+  secondStats?: VoteStats;
+  opening: boolean; // Sealed votes are waiting to be opened by their voters
+  startedAt: string;
+  duration?: number; // Seconds from the round's start to its reveal
 }
 
 interface PokerServer {
   id: string;
   players: Record<string, Player>;
-  currentSession: {
-    cardSet: string[];
-    votes: Record<string, string>;
-    isShown: boolean;
+  hostId: number;
+  currentSession: Session; // The main track
+  tracks?: Record<string, Session>; // Parallel tracks by name
+  stories: Story[];
+  storyIndex: number;
+  pinnedNote?: string; // Kept in view for everyone by the host
+  settings: {
+    chatEnabled: boolean;
+    anonymous: boolean;
+    hideVotesFromObservers: boolean;
+    autoReveal: boolean;
+    revealCountdown: number;
+    revealPolicy: 'anyone' | 'host';
+    resetPolicy: 'anyone' | 'host';
+    joinMessage: string;
+    kickCooldown: number;
+    estimateUnit: 'points' | 'time';
+    maxNameLength: number;
+    maxChatLength: number;
+    minVotersToReveal: number;
+    secondScale: string; // Deck picked from alongside each vote, empty for one scale
+    commitReveal: boolean;
+    joinAsObserver: boolean;
+    observersChatOnly: boolean;
+    hideOwnVote: boolean; // Voters don't see their own vote echoed back before the reveal
+    autoClearAfter: number; // Seconds until a revealed round is cleared, 0 for never
   };
 }
 
+interface Story {
+  title: string;
+  description?: string;
+  finalEstimate?: string;
+  skipped?: boolean; // Moved past without an estimate
+  url?: string;
+}
+
+interface VoteStats {
+  average: number;
+  median: number;
+  min: number;
+  max: number;
+  minVote: string;
+  maxVote: string;
+  total: number; // In hours when the room is time-based
+  spread: number;
+  highSpread: boolean;
+  numericVotes: number;
+  modes: string[];
+  coffeeBreak: boolean;
+  breakSuggested: boolean;
+  needsInfo: boolean;
+  distribution: Record<string, number>;
+  cards: string[]; // Keys of distribution in deck order
+}
+
 interface LogMessage {
   user: string;
-  message: string;
+  message: string; // In English
+  eventType: string;
+  params?: string[];
+  track?: string;
   timestamp: string;
 }
 
@@ -33,6 +110,42 @@ interface ChatMessage {
   timestamp: string;
 }
 
+interface TypingMessage {
+  user: string;
+  isTyping: boolean;
+}
+
+// WebSocket protocol version this client speaks; must match the server's ProtocolVersion
+const PROTOCOL_VERSION = 1;
+// How long a typing indicator is shown without a refresh before it is considered stale
+const TYPING_STALE_MS = 5000;
+// How long after the last keystroke we tell others we've stopped typing
+const TYPING_IDLE_MS = 2000;
+
+// Show whole numbers as-is and fractions to at most two places, so 0.25 stays 0.25
+const formatNumber = (n: number) => String(Number(n.toFixed(2)));
+
+// The vote the server records for a player who abstains with the abstain action
+const ABSTENTION = 'abstain';
+const cardLabel = (card: string) => card === ABSTENTION ? 'Abstained' : card;
+
+// Time-based rooms report their figures in hours
+const formatEstimate = (n: number, unit?: string) => unit === 'time' ? `${formatNumber(n)}h` : formatNumber(n);
+
+// Starting decks offered when creating a room
+const DECK_PRESETS: { name: string; cards: string; unit: 'points' | 'time' }[] = [
+  { name: 'Points', cards: '1,2,3,5,8', unit: 'points' },
+  { name: 'Fibonacci', cards: '0,1,2,3,5,8,13,21,?,☕', unit: 'points' },
+  { name: 'T-shirt', cards: 'XS,S,M,L,XL,?,☕', unit: 'points' },
+  { name: 'Time', cards: '1h,2h,4h,1d,2d,3d,5d', unit: 'time' },
+];
+
+// A player's color, so the same person is easy to spot in every list
+const PlayerDot = ({ color }: { color: string }) => (
+  <span className="d-inline-block rounded-circle mr-1 align-middle"
+        style={{ width: '0.6em', height: '0.6em', backgroundColor: color }}></span>
+);
+
 function App() {
   const [roomId, setRoomId] = useState<string | null>(() => {
     const path = window.location.pathname;
@@ -40,22 +153,43 @@ function App() {
     return match ? match[1] : null;
   });
   
+  // ?present shows the room read-only, e.g. on a shared screen, without joining it
+  const [presenting] = useState(() => new URLSearchParams(window.location.search).has('present'));
   const [server, setServer] = useState<PokerServer | null>(null);
   const [playerName, setPlayerName] = useState(() => localStorage.getItem('playerName') || '');
   const [rememberName, setRememberName] = useState(() => !!localStorage.getItem('playerName'));
-  const [playerType, setPlayerType] = useState<PlayerType>('Participant');
+  const [playerType, setPlayerType] = useState<PlayerType | ''>(''); // Empty for the room's default
+  const [playerGroup, setPlayerGroup] = useState(() => localStorage.getItem('playerGroup') || '');
   const [currentPlayer, setCurrentPlayer] = useState<Player | null>(null);
   const [isInitializing, setIsInitializing] = useState(true);
   const [cardSet, setCardSet] = useState('1,2,3,5,8');
+  const [estimateUnit, setEstimateUnit] = useState<'points' | 'time'>('points');
   const [logs, setLogs] = useState<LogMessage[]>([]);
   const [chats, setChats] = useState<ChatMessage[]>([]);
   const [chatInput, setChatInput] = useState('');
+  const [typingUsers, setTypingUsers] = useState<Record<string, number>>({});
   const [notifications, setNotifications] = useState<{id: string, text: string, type: string}[]>([]);
   const [chosenCard, setChosenCard] = useState<string | null>(null);
+  const [secondCard, setSecondCard] = useState<string | null>(null);
+  const [countdown, setCountdown] = useState<number | null>(null);
+  const [idleWarning, setIdleWarning] = useState(false);
+  const [joinMessage, setJoinMessage] = useState<string | null>(null);
+  // Latest vote tally from the server, superseded by each full update
+  const [progress, setProgress] = useState<{ track?: string; voted: number; total: number } | null>(null);
   
   const socketRef = useRef<WebSocket | null>(null);
   const recoveryId = useRef<string>(localStorage.getItem('recoveryId') || uuidv4());
   const chatEndRef = useRef<HTMLDivElement>(null);
+  const isTypingRef = useRef(false);
+  const typingTimeoutRef = useRef<ReturnType<typeof setTimeout> | null>(null);
+
+  // The track we vote in, empty for the main one, and its session
+  const myTrack = (currentPlayer && server?.players[currentPlayer.publicId]?.track) || '';
+  const session = (myTrack && server?.tracks?.[myTrack]) || server?.currentSession;
+  const myTrackRef = useRef('');
+  // The vote behind our commitment in a commit-reveal room, opened on reveal
+  const sealedRef = useRef<{ vote: string; nonce: string } | null>(null);
+  myTrackRef.current = myTrack;
 
   useEffect(() => {
     localStorage.setItem('recoveryId', recoveryId.current);
@@ -65,6 +199,18 @@ function App() {
     chatEndRef.current?.scrollIntoView({ behavior: 'smooth' });
   }, [chats]);
 
+  // Drop typing indicators that haven't been refreshed, e.g. because the typist disconnected
+  useEffect(() => {
+    const interval = setInterval(() => {
+      setTypingUsers(prev => {
+        const now = Date.now();
+        const active = Object.entries(prev).filter(([, expires]) => expires > now);
+        return active.length === Object.keys(prev).length ? prev : Object.fromEntries(active);
+      });
+    }, 1000);
+    return () => clearInterval(interval);
+  }, []);
+
   useEffect(() => {
     const handlePopState = () => {
       const path = window.location.pathname;
@@ -93,7 +239,7 @@ function App() {
 
   const connect = () => {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const ws = new WebSocket(`${protocol}//${window.location.host}/ws?roomId=${roomId}`);
+    const ws = new WebSocket(`${protocol}//${window.location.host}/ws?roomId=${roomId}${presenting ? '&present' : ''}`);
 
     ws.onopen = () => {
       addNotification('Connected to server', 'success');
@@ -101,13 +247,16 @@ function App() {
       
       // Auto-join if we have a player name
       const storedName = localStorage.getItem('playerName');
-      if (storedName) {
+      if (storedName && !presenting) {
         ws.send(JSON.stringify({
           action: 'join',
           payload: { 
             name: storedName, 
             recoveryId: recoveryId.current, 
-            type: playerType 
+            type: playerType,
+            group: localStorage.getItem('playerGroup') || '',
+            protocolVersion: PROTOCOL_VERSION,
+            deltas: true
           }
         }));
       }
@@ -118,31 +267,134 @@ function App() {
       switch(msg.type) {
         case 'join_success':
           setCurrentPlayer(msg.payload);
+          setPlayerName(msg.payload.name); // The server names players who left it blank
+          // The server rotates recovery IDs, so keep the one it just issued
+          if (msg.payload.recoveryId) {
+            recoveryId.current = msg.payload.recoveryId;
+            localStorage.setItem('recoveryId', msg.payload.recoveryId);
+          }
+          // Catch up on what happened before we connected
+          fetch(`/api/rooms/${roomId}/log`)
+            .then(res => res.ok ? res.json() : [])
+            .then((entries: LogMessage[]) => setLogs(entries.reverse().slice(0, 20)))
+            .catch(() => {});
           break;
         case 'updated':
           setServer(msg.payload);
+          setProgress(null);
+          if (!msg.payload?.currentSession.countingDown) {
+            setCountdown(null);
+          }
           if (msg.payload && currentPlayer) {
-            const myVote = msg.payload.currentSession.votes[currentPlayer.publicId.toString()];
+            const track = msg.payload.players[currentPlayer.publicId]?.track;
+            const mySession = (track && msg.payload.tracks?.[track]) || msg.payload.currentSession;
+            if (mySession.opening && sealedRef.current) {
+              ws.send(JSON.stringify({ action: 'openVote', payload: sealedRef.current }));
+              sealedRef.current = null;
+            }
+            const myVote = mySession.votes[currentPlayer.publicId.toString()];
             if (myVote && !chosenCard) {
               setChosenCard(myVote);
             }
           }
           break;
+        case 'vote_cast':
+          setServer(prev => {
+            if (!prev) return prev;
+            const { publicId, voted } = msg.payload;
+            const player = prev.players[publicId];
+            const track = player?.track && prev.tracks?.[player.track] ? player.track : '';
+            const session = track ? prev.tracks![track] : prev.currentSession;
+            const others = session.voted.filter(id => id !== publicId);
+            const updated = { ...session, voted: voted ? [...others, publicId].sort((a, b) => a - b) : others };
+            return {
+              ...prev,
+              players: player ? { ...prev.players, [publicId]: { ...player, mode: 'Awake', online: true } } : prev.players,
+              ...(track ? { tracks: { ...prev.tracks, [track]: updated } } : { currentSession: updated }),
+            };
+          });
+          break;
+        case 'progress':
+          if ((msg.payload.track ?? '') === myTrackRef.current) setProgress(msg.payload);
+          break;
+        case 'player_updated':
+          setServer(prev => prev && { ...prev, players: { ...prev.players, [msg.payload.publicId]: msg.payload } });
+          break;
         case 'log':
+          setIdleWarning(false); // Someone did something, so the room is active again
           setLogs(prev => [msg.payload, ...prev].slice(0, 20));
           break;
         case 'chat':
           setChats(prev => [...prev, msg.payload]);
+          setTypingUsers(prev => {
+            const { [msg.payload.user]: _, ...rest } = prev;
+            return rest;
+          });
+          break;
+        case 'chat_history':
+          setChats(msg.payload);
+          break;
+        case 'chat_cleared':
+          setChats([]);
+          break;
+        case 'room_reset':
+          setChats([]);
+          setLogs([]);
+          setChosenCard(null);
+          setSecondCard(null);
+          sealedRef.current = null;
+          break;
+        case 'typing': {
+          const typing: TypingMessage = msg.payload;
+          setTypingUsers(prev => {
+            const { [typing.user]: _, ...rest } = prev;
+            return typing.isTyping ? { ...rest, [typing.user]: Date.now() + TYPING_STALE_MS } : rest;
+          });
           break;
+        }
         case 'kicked':
           setCurrentPlayer(null);
           setRoomId(null);
           window.history.pushState({}, '', '/');
-          addNotification('You have been kicked from the room', 'danger');
+          addNotification(msg.payload?.retryIn
+            ? `You were removed from this room and can rejoin in ${msg.payload.retryIn} seconds`
+            : 'You have been kicked from the room', 'danger');
+          socketRef.current?.close();
+          break;
+        case 'type_changed':
+          setCurrentPlayer(msg.payload);
+          setChosenCard(null);
+          addNotification('The host made you an observer', 'warning');
+          break;
+        case 'room_closed':
+          setCurrentPlayer(null);
+          setRoomId(null);
+          window.history.pushState({}, '', '/');
+          addNotification(msg.payload.reason, 'danger');
           socketRef.current?.close();
           break;
+        case 'welcome':
+          if (PROTOCOL_VERSION < msg.payload.minProtocolVersion || PROTOCOL_VERSION > msg.payload.protocolVersion) {
+            addNotification('This page is out of date, please reload it', 'danger');
+          }
+          break;
+        case 'idle_warning':
+          setIdleWarning(true);
+          break;
+        case 'system':
+          setJoinMessage(msg.payload.message);
+          break;
+        case 'countdown':
+          setCountdown(msg.payload.remaining);
+          break;
+        case 'error':
+          addNotification(msg.payload.message, 'danger');
+          break;
         case 'clear':
+          if ((msg.payload?.track ?? '') !== myTrackRef.current) break; // Another track's round
           setChosenCard(null);
+          setSecondCard(null);
+          sealedRef.current = null;
           addNotification('Votes cleared', 'warning');
           break;
       }
@@ -168,10 +420,14 @@ function App() {
   const createRoom = async () => {
     const res = await fetch('/api/create', {
       method: 'POST',
-      body: JSON.stringify({ cardSet }),
+      body: JSON.stringify({ cardSet, settings: { estimateUnit } }),
       headers: { 'Content-Type': 'application/json' }
     });
     const data = await res.json();
+    if (!res.ok) {
+      addNotification(`Couldn't create the room: ${data.error}`, 'danger');
+      return;
+    }
     window.history.pushState({}, '', `/room/${data.id}`);
     setRoomId(data.id);
   };
@@ -179,15 +435,20 @@ function App() {
   const join = () => {
     if (rememberName) {
       localStorage.setItem('playerName', playerName);
+      localStorage.setItem('playerGroup', playerGroup);
     } else {
       localStorage.removeItem('playerName');
+      localStorage.removeItem('playerGroup');
     }
     socketRef.current?.send(JSON.stringify({
       action: 'join',
       payload: { 
         name: playerName, 
         recoveryId: recoveryId.current, 
-        type: playerType 
+        type: playerType,
+        group: playerGroup,
+        protocolVersion: PROTOCOL_VERSION,
+        deltas: true
       }
     }));
   };
@@ -200,45 +461,148 @@ function App() {
   };
 
   const vote = (card: string) => {
-    if (server?.currentSession.isShown) return;
+    if (session?.isShown || session?.locked) return;
     if (chosenCard === card) {
       setChosenCard(null);
+      sealedRef.current = null;
       socketRef.current?.send(JSON.stringify({ action: 'unvote' }));
+    } else if (card === ABSTENTION) {
+      setChosenCard(card);
+      setSecondCard(null);
+      sealedRef.current = null;
+      socketRef.current?.send(JSON.stringify({ action: 'abstain' }));
     } else {
       setChosenCard(card);
-      socketRef.current?.send(JSON.stringify({ action: 'vote', payload: { vote: card } }));
+      sendVote(card, secondCard);
+    }
+  };
+  // In a commit-reveal room only a hash of the card and a random nonce goes
+  // out now, and the card itself once the round is being opened
+  const sendVote = async (card: string, second: string | null) => {
+    let vote = card;
+    if (server?.settings.commitReveal) {
+      const nonce = Array.from(crypto.getRandomValues(new Uint8Array(16)), b => b.toString(16).padStart(2, '0')).join('');
+      const digest = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(`${card}:${nonce}`));
+      vote = Array.from(new Uint8Array(digest), b => b.toString(16).padStart(2, '0')).join('');
+      sealedRef.current = { vote: card, nonce };
+    }
+    socketRef.current?.send(JSON.stringify({ action: 'vote', payload: { vote, second: second || undefined } }));
+  };
+  // Picking a value on the second scale re-sends the vote if one is already cast
+  const voteSecond = (value: string) => {
+    if (session?.isShown || session?.locked) return;
+    const second = secondCard === value ? null : value;
+    setSecondCard(second);
+    if (chosenCard && chosenCard !== ABSTENTION) {
+      sendVote(chosenCard, second);
     }
   };
 
-  const show = () => socketRef.current?.send(JSON.stringify({ action: 'show' }));
+  const show = () => socketRef.current?.send(JSON.stringify({
+    action: 'show',
+    payload: { countdown: (server?.settings.revealCountdown ?? 0) > 0 },
+  }));
   const clear = () => socketRef.current?.send(JSON.stringify({ action: 'clear' }));
+  const hideVotes = () => socketRef.current?.send(JSON.stringify({ action: 'hideVotes' }));
   const kick = (publicId: number) => socketRef.current?.send(JSON.stringify({ action: 'kick', payload: { publicId } }));
   const changeType = (type: PlayerType) => socketRef.current?.send(JSON.stringify({ action: 'changeType', payload: { type } }));
+  const lockVoting = (locked: boolean) => socketRef.current?.send(JSON.stringify({ action: 'lockVoting', payload: { locked } }));
+  const clearChat = () => socketRef.current?.send(JSON.stringify({ action: 'clearChat' }));
+  const resetRoom = () => socketRef.current?.send(JSON.stringify({ action: 'resetRoom' }));
+  const pinNote = (note: string) => socketRef.current?.send(JSON.stringify({ action: 'pinNote', payload: { note } }));
+  const forceObserver = (publicId: number) => socketRef.current?.send(JSON.stringify({ action: 'forceObserver', payload: { publicId } }));
+  const changeDeck = (deck: string) => socketRef.current?.send(JSON.stringify({ action: 'changeDeck', payload: { deck } }));
+  const editDeck = (cards: string) => socketRef.current?.send(JSON.stringify({ action: 'editDeck', payload: { cards } }));
+  const createTrack = (name: string) => socketRef.current?.send(JSON.stringify({ action: 'createTrack', payload: { name } }));
+  const joinTrack = (name: string) => {
+    setChosenCard(null); // Moving withdraws our vote
+    socketRef.current?.send(JSON.stringify({ action: 'joinTrack', payload: { name } }));
+  };
+  const keepAlive = () => {
+    socketRef.current?.send(JSON.stringify({ action: 'keepAlive' }));
+    setIdleWarning(false);
+  };
+  const updateSettings = (settings: Partial<PokerServer['settings']>) =>
+    socketRef.current?.send(JSON.stringify({ action: 'updateSettings', payload: settings }));
+  const kickObservers = () => socketRef.current?.send(JSON.stringify({ action: 'kickObservers' }));
+  const moveStory = (action: 'nextStory' | 'prevStory' | 'skipStory') => socketRef.current?.send(JSON.stringify({ action }));
+  const setEstimate = (estimate: string) => socketRef.current?.send(JSON.stringify({ action: 'setEstimate', payload: { estimate } }));
+  const currentStory = server?.stories?.[server.storyIndex];
+  // Revealed vote counts in deck order
+  const distribution = (session?.stats?.cards || []).map(card => ({ card, count: session!.stats!.distribution[card] }));
+  const isHost = !!currentPlayer && server?.hostId === currentPlayer.publicId;
+  // Individual votes the server withholds from us, either for everyone or because we're observing
+  const votesHidden = !!server?.settings.anonymous || (!!server?.settings.hideVotesFromObservers && (presenting || currentPlayer?.type === 'Observer'));
+  // Observers the room limits to chatting can't steer the round either
+  const chatOnly = !isHost && currentPlayer?.type === 'Observer' && !!server?.settings.observersChatOnly;
+  const canReveal = !chatOnly && (isHost || server?.settings.revealPolicy !== 'host');
+  const canReset = !chatOnly && (isHost || server?.settings.resetPolicy !== 'host');
+  // Votes still needed before the room lets anyone reveal
+  const secondScale = server?.settings.secondScale || '';
+  const secondCards = (secondScale && server?.currentSession.decks[secondScale]) || [];
+  const votesNeeded = server && session && !session.isShown && server.settings.minVotersToReveal > 1
+    ? Math.max(0, server.settings.minVotersToReveal - session.voted.length) : 0;
+  // How many of my track's awake participants have voted
+  const tally = progress ?? (() => {
+    const voters = Object.values(server?.players || {})
+      .filter(p => p.type === 'Participant' && p.mode === 'Awake' && (p.track || '') === myTrack);
+    return { voted: voters.filter(p => session?.voted.includes(p.publicId)).length, total: voters.length };
+  })();
   
+  const chatEnabled = server?.settings.chatEnabled !== false;
+
+  const sendTyping = (isTyping: boolean) => {
+    if (isTypingRef.current === isTyping) return;
+    isTypingRef.current = isTyping;
+    socketRef.current?.send(JSON.stringify({ action: 'typing', payload: { isTyping } }));
+  };
+
+  const stopTyping = () => {
+    if (typingTimeoutRef.current) clearTimeout(typingTimeoutRef.current);
+    typingTimeoutRef.current = null;
+    sendTyping(false);
+  };
+
+  const onChatInput = (value: string) => {
+    setChatInput(value);
+    if (!value.trim() || !chatEnabled) {
+      stopTyping();
+      return;
+    }
+    sendTyping(true);
+    if (typingTimeoutRef.current) clearTimeout(typingTimeoutRef.current);
+    typingTimeoutRef.current = setTimeout(stopTyping, TYPING_IDLE_MS);
+  };
+
   const sendChat = (e?: React.FormEvent) => {
     e?.preventDefault();
-    if (!chatInput.trim()) return;
+    if (!chatInput.trim() || !chatEnabled) return;
+    stopTyping();
     socketRef.current?.send(JSON.stringify({ action: 'chat', payload: { message: chatInput } }));
     setChatInput('');
   };
 
+  const typingNames = Object.keys(typingUsers).filter(name => name !== playerName);
+
   const copyUrl = () => {
     navigator.clipboard.writeText(window.location.href);
     addNotification('URL copied to clipboard', 'success');
   };
 
   const voteStats = useMemo(() => {
-    if (!server?.currentSession.isShown || !server.currentSession.votes) return null;
-    const votes = Object.values(server.currentSession.votes).map(v => parseFloat(v)).filter(v => !isNaN(v));
-    if (votes.length === 0) return null;
-    const avg = votes.reduce((a, b) => a + b, 0) / votes.length;
-    
-    const counts: Record<string, number> = {};
-    Object.values(server.currentSession.votes).forEach(v => counts[v] = (counts[v] || 0) + 1);
-    const maxCount = Math.max(...Object.values(counts));
-    const modes = Object.keys(counts).filter(k => counts[k] === maxCount);
-
-    return { avg, modes };
+    const stats = session?.stats;
+    if (!session?.isShown || !stats) return null;
+    return {
+      avg: stats.numericVotes > 0 ? stats.average : null,
+      median: stats.numericVotes > 0 ? stats.median : null,
+      total: stats.numericVotes > 0 ? stats.total : null,
+      range: stats.numericVotes > 1 && stats.minVote !== stats.maxVote ? `${stats.minVote}–${stats.maxVote}` : null,
+      modes: stats.modes,
+      highSpread: stats.highSpread,
+      coffeeBreak: stats.coffeeBreak,
+      breakSuggested: stats.breakSuggested,
+      needsInfo: stats.needsInfo,
+    };
   }, [server]);
 
   return (
@@ -264,6 +628,9 @@ function App() {
                 <button className="btn btn-outline-info btn-sm mr-2" onClick={copyUrl}>
                   <span className="oi oi-share mr-1"></span> Share
                 </button>
+                <a className="btn btn-outline-secondary btn-sm mr-2" href={`${window.location.pathname}?present`} target="_blank" rel="noreferrer">
+                  <span className="oi oi-monitor mr-1"></span> Present
+                </a>
                 <button className="btn btn-outline-danger btn-sm" onClick={leave}>
                   <span className="oi oi-account-logout mr-1"></span> Exit Room
                 </button>
@@ -287,13 +654,62 @@ function App() {
                 <p className="lead text-muted">Estimate your tasks with zero friction. No accounts, no data tracking, just collaborative refinement.</p>
                 <div className="form-group mt-5">
                   <label className="font-weight-bold">Card Set Configuration</label>
+                  <div className="mb-2">
+                    {DECK_PRESETS.map(preset => (
+                      <button key={preset.name} type="button"
+                              className={`btn btn-sm mr-2 ${cardSet === preset.cards ? 'btn-primary' : 'btn-outline-secondary'}`}
+                              onClick={() => { setCardSet(preset.cards); setEstimateUnit(preset.unit); }}>
+                        {preset.name}
+                      </button>
+                    ))}
+                  </div>
                   <input className="form-control form-control-lg" value={cardSet} onChange={e => setCardSet(e.target.value)} />
                   <small className="form-text text-muted">Customise the deck using comma-separated values.</small>
+                  <div className="custom-control custom-switch mt-2">
+                    <input type="checkbox" className="custom-control-input" id="createTimeSwitch"
+                           checked={estimateUnit === 'time'}
+                           onChange={e => setEstimateUnit(e.target.checked ? 'time' : 'points')} />
+                    <label className="custom-control-label" htmlFor="createTimeSwitch">Cards are durations such as 4h or 2d</label>
+                  </div>
                 </div>
                 <button className="btn btn-primary btn-lg px-5 mt-4" onClick={createRoom}>Create Room</button>
               </div>
             </div>
           </div>
+        ) : presenting ? (
+          <div className="container">
+            {currentStory && <h2 className="font-weight-bold mb-4">{currentStory.title}</h2>}
+            <div className="d-flex flex-wrap justify-content-center">
+              {Object.values(server?.players || {})
+                .filter(p => p.type === 'Participant' && !p.track) // The main track is the one on screen
+                .sort((a, b) => a.publicId - b.publicId)
+                .map(p => {
+                  const hasVoted = session?.voted.includes(p.publicId);
+                  const voteValue = session?.votes[p.publicId];
+                  return (
+                    <div key={p.publicId} className={`text-center m-3 ${!p.online ? 'asleep' : ''}`}>
+                      <div className={`btn poker_card ${hasVoted ? 'selected' : ''}`}>
+                        {session?.isShown && !votesHidden ? (voteValue ? cardLabel(voteValue) : '-') : (hasVoted ? '✅' : '')}
+                      </div>
+                      <div className="h5 mt-2"><PlayerDot color={p.color} />{p.name}</div>
+                    </div>
+                  );
+                })}
+            </div>
+            {voteStats && (
+              <div className="text-center mt-4">
+                <span className="h3 mr-4">Avg: {voteStats.avg != null ? formatEstimate(voteStats.avg, server?.settings.estimateUnit) : '-'}</span>
+                <span className="h3">Mode: {voteStats.modes.join(', ') || '-'}</span>
+              </div>
+            )}
+            {session?.stats && votesHidden && (
+              <div className="d-flex flex-wrap justify-content-center mt-3">
+                {distribution.map(({ card, count }) => (
+                  <span key={card} className="badge badge-secondary m-1 p-2" style={{fontSize: '1.25rem'}}>{cardLabel(card)} × {count}</span>
+                ))}
+              </div>
+            )}
+          </div>
         ) : !currentPlayer ? (
           <div className="card shadow-lg mx-auto border-0" style={{maxWidth: '500px'}}>
             <div className="card-body p-4">
@@ -302,7 +718,7 @@ function App() {
               <form onSubmit={(e) => { e.preventDefault(); join(); }}>
                 <div className="form-group">
                   <label>Username</label>
-                  <input className="form-control" maxLength={20} value={playerName} onChange={e => setPlayerName(e.target.value)} />
+                  <input className="form-control" maxLength={server?.settings.maxNameLength ?? 32} placeholder="Leave blank for a random name" value={playerName} onChange={e => setPlayerName(e.target.value)} />
                 </div>
                 <div className="custom-control custom-checkbox mb-3">
                   <input type="checkbox" className="custom-control-input" id="rememberName" checked={rememberName} onChange={e => setRememberName(e.target.checked)} />
@@ -310,17 +726,40 @@ function App() {
                 </div>
                 <div className="form-group">
                   <label>Participation type</label>
-                  <select className="form-control custom-select" value={playerType} onChange={e => setPlayerType(e.target.value as PlayerType)}>
+                  <select className="form-control custom-select" value={playerType} onChange={e => setPlayerType(e.target.value as PlayerType | '')}>
+                    <option value="">Room default ({server?.settings.joinAsObserver ? 'Observer' : 'Participant'})</option>
                     <option value="Participant">Participant</option>
                     <option value="Observer">Observer</option>
                   </select>
                 </div>
-                <button type="submit" className="btn btn-primary btn-block btn-lg mt-4" disabled={!playerName}>Join</button>
+                <div className="form-group">
+                  <label>Team <small className="text-muted">(optional)</small></label>
+                  <input className="form-control" maxLength={32} value={playerGroup} onChange={e => setPlayerGroup(e.target.value)} />
+                  <small className="form-text text-muted">Revealed votes are also broken down by team.</small>
+                </div>
+                <button type="submit" className="btn btn-primary btn-block btn-lg mt-4">Join</button>
               </form>
             </div>
           </div>
         ) : (
           <div className="row">
+            {joinMessage && (
+              <div className="col-12">
+                <div className="alert alert-info d-flex justify-content-between align-items-start" style={{whiteSpace: 'pre-line'}}>
+                  <span>{joinMessage}</span>
+                  <button type="button" className="close ml-3" aria-label="Dismiss" onClick={() => setJoinMessage(null)}>
+                    <span aria-hidden="true">&times;</span>
+                  </button>
+                </div>
+              </div>
+            )}
+            {server?.pinnedNote && (
+              <div className="col-12">
+                <div className="alert alert-warning">
+                  <span role="img" aria-label="Pinned">📌</span> {server.pinnedNote}
+                </div>
+              </div>
+            )}
             {/* Left Column: People, Log, Chat (rearranged to 2 major cols) */}
             <div className="col-lg-8">
               {/* Poker Cards */}
@@ -329,17 +768,43 @@ function App() {
                   <h5 className="card-title font-weight-bold">Poker cards</h5>
                   <h6 className="card-subtitle mb-3 text-muted">Click on a card to cast your vote</h6>
                                         <div className="d-flex flex-wrap justify-content-center">
-                                      {server?.currentSession.cardSet.map(card => (
+                                      {session?.cardSet.map(card => (
                                         <button 
                                           key={card} 
                                           className={`btn poker_card ${chosenCard === card ? 'selected' : ''}`}
                                           onClick={() => vote(card)}
-                                          disabled={currentPlayer.type === 'Observer' || server?.currentSession.isShown}
+                                          disabled={currentPlayer.type === 'Observer' || session?.isShown || session?.locked}
                                         >
                                           {card}
                                         </button>
                                       ))}
-                                    </div>                </div>
+                                    </div>
+                  <div className="text-center mt-2">
+                    <button className={`btn btn-sm ${chosenCard === ABSTENTION ? 'btn-secondary' : 'btn-outline-secondary'}`}
+                            onClick={() => vote(ABSTENTION)}
+                            title="No estimate to give, without holding up the reveal"
+                            disabled={currentPlayer.type === 'Observer' || session?.isShown || session?.locked}>
+                      Abstain
+                    </button>
+                  </div>
+                  {secondCards.length > 0 && (
+                    <>
+                      <h6 className="text-muted text-center mt-3 mb-2">{secondScale}</h6>
+                      <div className="d-flex flex-wrap justify-content-center">
+                        {secondCards.map(value => (
+                          <button
+                            key={value}
+                            className={`btn poker_card ${secondCard === value ? 'selected' : ''}`}
+                            onClick={() => voteSecond(value)}
+                            disabled={currentPlayer.type === 'Observer' || session?.isShown || session?.locked}
+                          >
+                            {value}
+                          </button>
+                        ))}
+                      </div>
+                    </>
+                  )}
+                </div>
               </div>
 
               <div className="row">
@@ -347,14 +812,260 @@ function App() {
                   <div className="card shadow-sm h-100">
                     <div className="card-body">
                       <h6 className="font-weight-bold">Session Controls</h6>
+                      {currentStory && (
+                        <div className="mt-2">
+                          <small className="text-muted">Story {server!.storyIndex + 1} of {server!.stories.length}</small>
+                          <div className="font-weight-bold">
+                            {currentStory.url ? <a href={currentStory.url} target="_blank" rel="noreferrer">{currentStory.title}</a> : currentStory.title}
+                          </div>
+                          {currentStory.description && <small>{currentStory.description}</small>}
+                          {currentStory.finalEstimate && (
+                            <div><span className="badge badge-success">Estimate: {currentStory.finalEstimate}</span></div>
+                          )}
+                          {currentStory.skipped && (
+                            <div><span className="badge badge-secondary">Skipped</span></div>
+                          )}
+                          {isHost && session?.isShown && (
+                            <select className="form-control form-control-sm custom-select mt-2"
+                                    value={currentStory.finalEstimate || ''}
+                                    onChange={e => setEstimate(e.target.value)}>
+                              <option value="">Record estimate…</option>
+                              {session.cardSet.map(card => (
+                                <option key={card} value={card}>{card}</option>
+                              ))}
+                            </select>
+                          )}
+                          {isHost && (
+                            <div className="row mt-2">
+                              <div className="col-4">
+                                <button className="btn btn-outline-secondary btn-block btn-sm"
+                                        onClick={() => moveStory('prevStory')}
+                                        disabled={server!.storyIndex === 0}>Previous</button>
+                              </div>
+                              <div className="col-4">
+                                <button className="btn btn-outline-secondary btn-block btn-sm"
+                                        onClick={() => moveStory('skipStory')}
+                                        title="Move on without an estimate">Skip</button>
+                              </div>
+                              <div className="col-4">
+                                <button className="btn btn-outline-secondary btn-block btn-sm"
+                                        onClick={() => moveStory('nextStory')}
+                                        disabled={server!.storyIndex >= server!.stories.length - 1}>Next</button>
+                              </div>
+                            </div>
+                          )}
+                        </div>
+                      )}
+                      {session && !session.isShown && tally.total > 0 && (
+                        <div className="mt-3">
+                          <div className="d-flex justify-content-between small text-muted mb-1">
+                            <span>Votes</span>
+                            <span>{tally.voted} of {tally.total} voted</span>
+                          </div>
+                          <div className="progress" style={{height: '0.5rem'}}>
+                            <div className="progress-bar bg-success" style={{width: `${100 * tally.voted / tally.total}%`}}></div>
+                          </div>
+                        </div>
+                      )}
                       <div className="row mt-3">
                         <div className="col-6">
-                          <button className="btn btn-outline-primary btn-block btn-sm" onClick={clear}>Clear</button>
+                          <button className="btn btn-outline-primary btn-block btn-sm" onClick={clear} disabled={!canReset}>Clear</button>
                         </div>
                         <div className="col-6">
-                          <button className="btn btn-primary btn-block btn-sm" onClick={show}>Show</button>
+                          <button className="btn btn-primary btn-block btn-sm" onClick={show} disabled={countdown !== null || !canReveal}>
+                            {countdown !== null ? `Revealing in ${countdown}…` : 'Show'}
+                          </button>
                         </div>
                       </div>
+                      {votesNeeded > 0 && (
+                        <small className="form-text text-muted text-center">
+                          {votesNeeded} more {votesNeeded === 1 ? 'vote is' : 'votes are'} needed before revealing
+                        </small>
+                      )}
+                      {isHost && session?.isShown && (
+                        <button className="btn btn-outline-warning btn-block btn-sm mt-2" onClick={hideVotes}>
+                          Hide votes again
+                        </button>
+                      )}
+                      {isHost && (
+                        <button className="btn btn-outline-secondary btn-block btn-sm mt-2"
+                                onClick={() => lockVoting(!server?.currentSession.locked)}>
+                          {server?.currentSession.locked ? 'Unlock voting' : 'Lock voting'}
+                        </button>
+                      )}
+                      {isHost && (
+                        <div className="mt-2">
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="autoRevealSwitch"
+                                   checked={!!server?.settings.autoReveal}
+                                   onChange={e => updateSettings({ autoReveal: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="autoRevealSwitch">Auto reveal</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="anonymousSwitch"
+                                   checked={!!server?.settings.anonymous}
+                                   onChange={e => updateSettings({ anonymous: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="anonymousSwitch">Anonymous</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="hideFromObserversSwitch"
+                                   checked={!!server?.settings.hideVotesFromObservers}
+                                   onChange={e => updateSettings({ hideVotesFromObservers: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="hideFromObserversSwitch">Hide votes from observers</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="hostRevealSwitch"
+                                   checked={server?.settings.revealPolicy === 'host'}
+                                   onChange={e => updateSettings({ revealPolicy: e.target.checked ? 'host' : 'anyone' })} />
+                            <label className="custom-control-label" htmlFor="hostRevealSwitch">Only host can reveal</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="hostResetSwitch"
+                                   checked={server?.settings.resetPolicy === 'host'}
+                                   onChange={e => updateSettings({ resetPolicy: e.target.checked ? 'host' : 'anyone' })} />
+                            <label className="custom-control-label" htmlFor="hostResetSwitch">Only host can clear</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="timeUnitSwitch"
+                                   checked={server?.settings.estimateUnit === 'time'}
+                                   onChange={e => updateSettings({ estimateUnit: e.target.checked ? 'time' : 'points' })} />
+                            <label className="custom-control-label" htmlFor="timeUnitSwitch">Cards are durations</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="commitRevealSwitch"
+                                   checked={!!server?.settings.commitReveal}
+                                   onChange={e => updateSettings({ commitReveal: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="commitRevealSwitch">Seal votes until reveal</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="joinAsObserverSwitch"
+                                   checked={!!server?.settings.joinAsObserver}
+                                   onChange={e => updateSettings({ joinAsObserver: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="joinAsObserverSwitch">New players join as observers</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="observersChatOnlySwitch"
+                                   checked={!!server?.settings.observersChatOnly}
+                                   onChange={e => updateSettings({ observersChatOnly: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="observersChatOnlySwitch">Observers can only chat</label>
+                          </div>
+                          <div className="custom-control custom-switch">
+                            <input type="checkbox" className="custom-control-input" id="hideOwnVoteSwitch"
+                                   checked={!!server?.settings.hideOwnVote}
+                                   onChange={e => updateSettings({ hideOwnVote: e.target.checked })} />
+                            <label className="custom-control-label" htmlFor="hideOwnVoteSwitch">Hide voters' own votes until reveal</label>
+                          </div>
+                          <div className="form-group mt-2 mb-0">
+                            <label className="small text-muted mb-1" htmlFor="kickCooldownSelect">Kicked players can rejoin after</label>
+                            <select className="form-control form-control-sm custom-select" id="kickCooldownSelect"
+                                    value={server?.settings.kickCooldown ?? 60}
+                                    onChange={e => updateSettings({ kickCooldown: Number(e.target.value) })}>
+                              <option value={0}>Immediately</option>
+                              <option value={60}>1 minute</option>
+                              <option value={300}>5 minutes</option>
+                              <option value={900}>15 minutes</option>
+                              <option value={3600}>1 hour</option>
+                            </select>
+                          </div>
+                          <div className="form-group mt-2 mb-0">
+                            <label className="small text-muted mb-1" htmlFor="autoClearSelect">Start the next round after a reveal</label>
+                            <select className="form-control form-control-sm custom-select" id="autoClearSelect"
+                                    value={server?.settings.autoClearAfter ?? 0}
+                                    onChange={e => updateSettings({ autoClearAfter: Number(e.target.value) })}>
+                              <option value={0}>Manually</option>
+                              <option value={15}>After 15 seconds</option>
+                              <option value={30}>After 30 seconds</option>
+                              <option value={60}>After 1 minute</option>
+                              <option value={120}>After 2 minutes</option>
+                            </select>
+                          </div>
+                          <div className="form-group mt-2 mb-0">
+                            <label className="small text-muted mb-1" htmlFor="secondScaleSelect">Second scale</label>
+                            <select className="form-control form-control-sm custom-select" id="secondScaleSelect"
+                                    value={secondScale}
+                                    onChange={e => updateSettings({ secondScale: e.target.value })}>
+                              <option value="">Off</option>
+                              {Object.keys(server?.currentSession.decks || {}).sort().map(deck => (
+                                <option key={deck} value={deck}>{deck}</option>
+                              ))}
+                            </select>
+                          </div>
+                          <div className="form-group mt-2 mb-0">
+                            <label className="small text-muted mb-1" htmlFor="minVotersInput">Votes needed to reveal</label>
+                            <input type="number" className="form-control form-control-sm" id="minVotersInput" min={1}
+                                   key={server?.settings.minVotersToReveal}
+                                   defaultValue={server?.settings.minVotersToReveal ?? 1}
+                                   onBlur={e => Number(e.target.value) !== server?.settings.minVotersToReveal && updateSettings({ minVotersToReveal: Number(e.target.value) })} />
+                          </div>
+                          <div className="form-group mt-2 mb-0">
+                            <label className="small text-muted mb-1" htmlFor="joinMessageInput">Message shown on join</label>
+                            <textarea className="form-control form-control-sm" id="joinMessageInput" rows={2} maxLength={500}
+                                      key={server?.settings.joinMessage}
+                                      defaultValue={server?.settings.joinMessage}
+                                      onBlur={e => e.target.value !== server?.settings.joinMessage && updateSettings({ joinMessage: e.target.value })} />
+                          </div>
+                          <div className="form-group mt-2 mb-0">
+                            <label className="small text-muted mb-1" htmlFor="pinnedNoteInput">Pinned note (empty to unpin)</label>
+                            <input type="text" className="form-control form-control-sm" id="pinnedNoteInput" maxLength={200}
+                                   key={server?.pinnedNote}
+                                   defaultValue={server?.pinnedNote}
+                                   onBlur={e => e.target.value !== (server?.pinnedNote ?? '') && pinNote(e.target.value)} />
+                          </div>
+                        </div>
+                      )}
+                      {Object.keys(server?.tracks || {}).length > 0 && (
+                        <div className="form-group mt-3 mb-0">
+                          <label className="small text-muted mb-1" htmlFor="trackSelect">Track</label>
+                          <select className="form-control form-control-sm custom-select" id="trackSelect"
+                                  value={myTrack}
+                                  onChange={e => joinTrack(e.target.value)}>
+                            <option value="">Main</option>
+                            {Object.keys(server?.tracks || {}).sort().map(track => (
+                              <option key={track} value={track}>{track}</option>
+                            ))}
+                          </select>
+                        </div>
+                      )}
+                      {isHost && (
+                        <div className="form-group mt-2 mb-0">
+                          <label className="small text-muted mb-1" htmlFor="createTrackInput">Open a parallel track</label>
+                          <input className="form-control form-control-sm" id="createTrackInput" maxLength={32} placeholder="Track name"
+                                 onKeyDown={e => {
+                                   if (e.key === 'Enter' && e.currentTarget.value.trim()) {
+                                     createTrack(e.currentTarget.value);
+                                     e.currentTarget.value = '';
+                                   }
+                                 }} />
+                        </div>
+                      )}
+                      {isHost && (
+                        <button className="btn btn-outline-danger btn-block btn-sm mt-2" onClick={resetRoom}>
+                          Reset room
+                        </button>
+                      )}
+                      {isHost && Object.values(server?.players || {}).some(p => p.type === 'Observer' && p.publicId !== currentPlayer?.publicId) && (
+                        <button className="btn btn-outline-danger btn-block btn-sm mt-2" onClick={kickObservers}>
+                          Remove observers
+                        </button>
+                      )}
+                      {isHost && Object.keys(server?.currentSession.decks || {}).length > 1 && (
+                        <select className="form-control form-control-sm custom-select mt-3"
+                                value={server?.currentSession.activeDeck}
+                                onChange={e => changeDeck(e.target.value)}>
+                          {Object.keys(server?.currentSession.decks || {}).sort().map(deck => (
+                            <option key={deck} value={deck}>{deck}</option>
+                          ))}
+                        </select>
+                      )}
+                      {isHost && (
+                        <div className="form-group mt-2 mb-0">
+                          <label className="small text-muted mb-1" htmlFor="editDeckInput">Cards (clears the votes)</label>
+                          <input className="form-control form-control-sm" id="editDeckInput"
+                                 key={server?.currentSession.cardSet.join(',')}
+                                 defaultValue={server?.currentSession.cardSet.join(',')}
+                                 onKeyDown={e => e.key === 'Enter' && e.currentTarget.value.trim() && editDeck(e.currentTarget.value)} />
+                        </div>
+                      )}
                     </div>
                   </div>
                 </div>
@@ -362,18 +1073,90 @@ function App() {
                   <div className="card shadow-sm h-100">
                     <div className="card-body">
                       <h6 className="font-weight-bold">Results</h6>
-                      {server?.currentSession.isShown ? (
+                      {session?.isShown ? (
                         <div className="mt-2">
                           <div className="d-flex justify-content-between align-items-center mb-2">
                             <span className="text-muted">Avg:</span>
                             <span className="font-weight-bold" style={{fontSize: '2rem', color: 'var(--success-color)'}}>
-                              {voteStats ? (voteStats.avg % 1 === 0 ? voteStats.avg : voteStats.avg.toFixed(1)) : '-'}
+                              {voteStats?.avg != null ? formatEstimate(voteStats.avg, server?.settings.estimateUnit) : '-'}
                             </span>
                           </div>
+                          {voteStats?.median != null && (
+                            <div className="d-flex justify-content-between align-items-center mb-2">
+                              <span className="text-muted">Median:</span>
+                              <span className="font-weight-bold">
+                                {formatEstimate(voteStats.median, server?.settings.estimateUnit)}
+                                {voteStats.range && <span className="text-muted small ml-2">({voteStats.range})</span>}
+                              </span>
+                            </div>
+                          )}
+                          {server?.settings.estimateUnit === 'time' && voteStats?.total != null && (
+                            <div className="d-flex justify-content-between align-items-center mb-2">
+                              <span className="text-muted">Total:</span>
+                              <span className="font-weight-bold">{formatEstimate(voteStats.total, 'time')}</span>
+                            </div>
+                          )}
                           <div className="d-flex justify-content-between align-items-center">
                             <span className="text-muted">Mode:</span>
                             <span className="font-weight-bold" style={{fontSize: '1.5rem'}}>{voteStats?.modes.join(', ') || '-'}</span>
                           </div>
+                          {session?.secondStats && (
+                            <div className="d-flex justify-content-between align-items-center">
+                              <span className="text-muted">{secondScale || 'Second scale'} mode:</span>
+                              <span className="font-weight-bold">{session.secondStats.modes.join(', ') || '-'}</span>
+                            </div>
+                          )}
+                          {session?.duration != null && (
+                            <div className="d-flex justify-content-between align-items-center">
+                              <span className="text-muted">Took:</span>
+                              <span className="font-weight-bold">
+                                {session.duration >= 60 && `${Math.floor(session.duration / 60)}m `}{session.duration % 60}s
+                              </span>
+                            </div>
+                          )}
+                          {session?.stats && Object.keys(session.stats.distribution).length > 0 && (
+                            <div className="small mt-2">
+                              {distribution.map(({ card, count }) => (
+                                <div key={card} className="d-flex align-items-center">
+                                  <span className="text-muted" style={{width: '3rem'}}>{cardLabel(card)}</span>
+                                  <div className="progress flex-grow-1 mx-2" style={{height: '0.5rem'}}>
+                                    <div className="progress-bar"
+                                         style={{width: `${100 * count / session.voted.length}%`}}></div>
+                                  </div>
+                                  <span>{count} {count === 1 ? 'vote' : 'votes'}</span>
+                                </div>
+                              ))}
+                            </div>
+                          )}
+                          {session?.groupStats && (
+                            <div className="small mt-2">
+                              {Object.entries(session.groupStats).sort(([a], [b]) => a.localeCompare(b)).map(([group, stats]) => (
+                                <div key={group} className={`d-flex justify-content-between ${stats.highSpread ? 'text-warning' : ''}`}>
+                                  <span className="text-muted">{group}</span>
+                                  <span>
+                                    {stats.numericVotes > 0 ? `avg ${formatEstimate(stats.average, server?.settings.estimateUnit)}` : '-'}
+                                    {stats.modes.length > 0 && ` · mode ${stats.modes.join(', ')}`}
+                                  </span>
+                                </div>
+                              ))}
+                            </div>
+                          )}
+                          {session?.voteOrder && session.voteOrder.length > 1 && (
+                            <div className="small text-muted mt-2">
+                              Fastest voter: {server?.players[session.voteOrder[0].publicId]?.name || 'Unknown'}
+                            </div>
+                          )}
+                          {voteStats?.needsInfo && (
+                            <div className="small text-info mt-2">Someone needs more information before estimating.</div>
+                          )}
+                          {voteStats?.highSpread && !voteStats?.needsInfo && (
+                            <div className="small text-warning mt-2">Estimates are far apart — worth discussing before re-voting.</div>
+                          )}
+                          {voteStats?.coffeeBreak && (
+                            <div className="small text-muted mt-1">
+                              {voteStats?.breakSuggested ? 'Everyone wants a break — time for coffee ☕' : 'Someone could use a coffee break ☕'}
+                            </div>
+                          )}
                         </div>
                       ) : (
                         <div className="text-center py-4 text-muted small italic">Votes hidden</div>
@@ -399,19 +1182,21 @@ function App() {
                       </thead>
                       <tbody>
                         {Object.values(server?.players || {})
-                          .filter(p => p.type === 'Participant')
+                          .filter(p => p.type === 'Participant' && (p.track || '') === myTrack)
                           .sort((a,b) => a.publicId - b.publicId)
                           .map(p => {
-                            const hasVoted = server?.currentSession.votes[p.publicId];
+                            const hasVoted = session?.voted.includes(p.publicId);
+                            const secondValue = session?.secondVotes?.[p.publicId];
+                            const voteValue = session?.votes[p.publicId] && (secondValue ? `${session.votes[p.publicId]} / ${secondValue}` : cardLabel(session.votes[p.publicId]));
                             return (
-                              <tr key={p.publicId} className={`${p.mode === 'Asleep' ? 'asleep' : ''} ${hasVoted ? 'table-success' : ''}`}>
+                              <tr key={p.publicId} className={`${!p.online ? 'asleep' : ''} ${hasVoted ? 'table-success' : ''}`}>
                                 <td>
-                                  {hasVoted && p.mode === 'Awake' && <span className="oi oi-check text-success"></span>}
-                                  {p.mode === 'Asleep' && <span className="oi oi-moon"></span>}
+                                  {hasVoted && p.online && <span className="oi oi-check text-success"></span>}
+                                  {!p.online && <span className="oi oi-moon"></span>}
                                 </td>
-                                <td className="small font-weight-bold">{p.name}</td>
+                                <td className="small font-weight-bold"><PlayerDot color={p.color} />{p.name}{p.group && <span className="badge badge-light ml-1">{p.group}</span>}</td>
                                 <td className="small">
-                                  {server?.currentSession.isShown ? (hasVoted || '-') : (hasVoted ? '✅' : '-')}
+                                  {session?.isShown && !votesHidden ? (voteValue || '-') : (hasVoted ? '✅' : '-')}
                                 </td>
                                 <td className="text-right">
                                   {p.publicId === currentPlayer.publicId && (
@@ -421,6 +1206,13 @@ function App() {
                                       <span className="oi oi-loop"></span>
                                     </button>
                                   )}
+                                  {isHost && p.publicId !== currentPlayer.publicId && (
+                                    <button className="btn btn-link changetype-btn p-0 mr-2"
+                                            title="Make Observer"
+                                            onClick={() => forceObserver(p.publicId)}>
+                                      <span className="oi oi-eye"></span>
+                                    </button>
+                                  )}
                                   <button className="btn btn-link kick-btn p-0" onClick={() => kick(p.publicId)}>
                                     <span className="oi oi-x"></span>
                                   </button>
@@ -453,11 +1245,11 @@ function App() {
                             .filter(p => p.type === 'Observer')
                             .sort((a,b) => a.publicId - b.publicId)
                             .map(p => (
-                              <tr key={p.publicId} className={p.mode === 'Asleep' ? 'asleep' : ''}>
+                              <tr key={p.publicId} className={!p.online ? 'asleep' : ''}>
                                 <td>
-                                  {p.mode === 'Asleep' && <span className="oi oi-moon"></span>}
+                                  {!p.online && <span className="oi oi-moon"></span>}
                                 </td>
-                                <td className="small font-weight-bold">{p.name}</td>
+                                <td className="small font-weight-bold"><PlayerDot color={p.color} />{p.name}{p.group && <span className="badge badge-light ml-1">{p.group}</span>}</td>
                                 <td className="text-right">
                                   {p.publicId === currentPlayer.publicId && (
                                     <button className="btn btn-link changetype-btn p-0 mr-2" 
@@ -498,8 +1290,11 @@ function App() {
             {/* Right Column: Chat */}
             <div className="col-lg-4">
               <div className="card shadow-sm d-flex flex-column chat-panel">
-                <div className="card-header bg-transparent border-bottom">
+                <div className="card-header bg-transparent border-bottom d-flex justify-content-between align-items-center">
                   <h6 className="mb-0 font-weight-bold">Chat</h6>
+                  {isHost && chats.length > 0 && (
+                    <button className="btn btn-link btn-sm text-muted p-0" onClick={clearChat}>Clear</button>
+                  )}
                 </div>
                 <div className="card-body d-flex flex-column overflow-auto p-3 flex-grow-1" style={{background: 'rgba(0,0,0,0.02)', minHeight: 0, flex: '1 1 0'}}>
                   {chats.map((c, i) => (
@@ -514,16 +1309,23 @@ function App() {
                   ))}
                   <div ref={chatEndRef} />
                 </div>
+                {typingNames.length > 0 && (
+                  <div className="px-3 py-1 text-muted small font-italic">
+                    {typingNames.join(', ')} {typingNames.length === 1 ? 'is' : 'are'} typing…
+                  </div>
+                )}
                 <div className="card-footer bg-transparent border-top p-2">
                   <form onSubmit={sendChat} className="input-group">
                     <input 
                       className="form-control form-control-sm" 
-                      placeholder="Type a message..." 
+                      placeholder={chatEnabled ? 'Type a message...' : 'Chat is disabled in this room'}
+                      disabled={!chatEnabled}
+                      maxLength={server?.settings.maxChatLength}
                       value={chatInput}
-                      onChange={e => setChatInput(e.target.value)}
+                      onChange={e => onChatInput(e.target.value)}
                     />
                     <div className="input-group-append">
-                      <button className="btn btn-primary btn-sm" type="submit">
+                      <button className="btn btn-primary btn-sm" type="submit" disabled={!chatEnabled}>
                         <span className="oi oi-share-accessible mr-1"></span> Send
                       </button>
                     </div>
@@ -549,6 +1351,13 @@ function App() {
       </div>
 
       <div className="notifications">
+        {idleWarning && (
+          <div className="notification alert-warning">
+            <span className="oi oi-clock mr-2"></span>
+            This room will close soon due to inactivity.
+            <button className="btn btn-sm btn-warning ml-2" onClick={keepAlive}>Keep it open</button>
+          </div>
+        )}
         {notifications.map(n => (
           <div key={n.id} className={`notification alert-${n.type}`}>
             <span className={`oi oi-${n.type === 'success' ? 'check' : n.type === 'danger' ? 'warning' : 'info'} mr-2`}></span>